@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Availability payloads, the Home Assistant defaults.
+const (
+	AvailabilityOnline  = "online"
+	AvailabilityOffline = "offline"
+)
+
+// Sub-topics of the retained availability messages. The poller's goes offline
+// through the MQTT last will; the inverter's while it stops answering QPIGS.
+const (
+	availabilitySubTopic         = "availability"
+	inverterAvailabilitySubTopic = "inverter/availability"
+)
+
+// inverterUnreachableAfter is how many QPIGS polls in a row may fail before
+// the inverter is reported offline, so one lost frame does not flap it.
+const inverterUnreachableAfter = 3
+
+// Topic classes, each with its own retain flag.
+const (
+	TopicClassLive    = "live"    // status that is stale the moment the poller stops
+	TopicClassStatic  = "static"  // ratings, device info and options that rarely change
+	TopicClassEnergy  = "energy"  // counters
+	TopicClassControl = "control" // switch states and setting results
+)
+
+// defaultRetain applies to classes missing from the "retain" section.
+var defaultRetain = map[string]bool{
+	TopicClassLive:    false,
+	TopicClassStatic:  true,
+	TopicClassEnergy:  true,
+	TopicClassControl: true,
+}
+
+// topicClasses maps sub-topics (and everything below them) to their class;
+// any other sub-topic is live.
+var topicClasses = []struct {
+	subTopic string
+	class    string
+}{
+	{"rating", TopicClassStatic},
+	{"device_info", TopicClassStatic},
+	{"charge_current_options", TopicClassStatic},
+	{"schedule", TopicClassStatic},
+	{"led", TopicClassStatic},
+	{"energy", TopicClassEnergy},
+	{"battery_control", TopicClassControl},
+	{"set", TopicClassControl},
+}
+
+// TopicClass returns the class of a sub-topic.
+func TopicClass(subTopic string) string {
+	for _, topic := range topicClasses {
+		if subTopic == topic.subTopic || strings.HasPrefix(subTopic, topic.subTopic+"/") {
+			return topic.class
+		}
+	}
+	return TopicClassLive
+}
+
+// RetainConfig holds the "retain" section of the config file, e.g.
+// {"live": false, "static": true}.
+type RetainConfig map[string]bool
+
+// Retain reports whether messages of a topic class are published retained.
+func (c RetainConfig) Retain(class string) bool {
+	if retain, ok := c[class]; ok {
+		return retain
+	}
+	return defaultRetain[class]
+}
+
+// InverterReachability publishes whether the inverter answers, as opposed to
+// the poller's own availability.
+type InverterReachability struct {
+	publisher *MQTTPublisher
+	failures  int
+	state     string // last payload published
+}
+
+// NewInverterReachability creates a tracker; nothing is published until the first Report.
+func NewInverterReachability(publisher *MQTTPublisher) *InverterReachability {
+	return &InverterReachability{publisher: publisher}
+}
+
+// Report records the outcome of a QPIGS poll and publishes a change of state.
+func (r *InverterReachability) Report(answered bool) {
+	if answered {
+		r.failures = 0
+		r.publish(AvailabilityOnline)
+		return
+	}
+	r.failures++
+	if r.failures >= inverterUnreachableAfter {
+		r.publish(AvailabilityOffline)
+	}
+}
+
+func (r *InverterReachability) publish(state string) {
+	if state == r.state {
+		return
+	}
+	if err := r.publisher.PublishRetained(r.publisher.Topic(inverterAvailabilitySubTopic), []byte(state)); err != nil {
+		fmt.Printf("Error publishing inverter availability to MQTT: %v\n", err)
+		return
+	}
+	fmt.Printf("Inverter is %s.\n", state)
+	r.state = state
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// backfillEarliestYear bounds the QEY probe so a unit that reports energy for
+// every year cannot make the search run forever.
+const backfillEarliestYear = 2000
+
+// EnergyDayRecord holds the PV generated and load consumed energy of one day, in Wh.
+type EnergyDayRecord struct {
+	Date         string
+	PVGenerated  int
+	LoadConsumed int
+}
+
+// findEarliestEnergyYear walks QEY backwards from the year of today and returns
+// the earliest year before the first year without any PV energy.
+func findEarliestEnergyYear(communicator *InverterCommunicator, parser *InverterParser, today time.Time) (int, error) {
+	earliest := today.Year()
+	for year := today.Year(); year >= backfillEarliestYear; year-- {
+		date := time.Date(year, time.January, 1, 0, 0, 0, 0, today.Location())
+		energyWh, err := queryEnergy(communicator, parser, QEYCommand(date))
+		time.Sleep(300 * time.Millisecond)
+		if err != nil {
+			if year == today.Year() {
+				return 0, err
+			}
+			break
+		}
+		fmt.Printf("Backfill: %s reports %d Wh\n", QEYCommand(date), energyWh)
+		if energyWh == 0 && year != today.Year() {
+			break
+		}
+		earliest = year
+	}
+	return earliest, nil
+}
+
+// backfillEnergyDays walks QED/QLD for every day from start to end inclusive.
+// Days the inverter cannot report are logged and skipped.
+func backfillEnergyDays(communicator *InverterCommunicator, parser *InverterParser, start, end time.Time) []EnergyDayRecord {
+	var records []EnergyDayRecord
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		pvGenerated, err := queryEnergy(communicator, parser, QEDCommand(day))
+		time.Sleep(300 * time.Millisecond)
+		if err != nil {
+			fmt.Printf("Backfill: skipping %s: %v\n", day.Format("2006-01-02"), err)
+			continue
+		}
+		loadConsumed, err := queryEnergy(communicator, parser, QLDCommand(day))
+		time.Sleep(300 * time.Millisecond)
+		if err != nil {
+			fmt.Printf("Backfill: skipping %s: %v\n", day.Format("2006-01-02"), err)
+			continue
+		}
+
+		fmt.Printf("Backfill: %s PV %d Wh, load %d Wh\n", day.Format("2006-01-02"), pvGenerated, loadConsumed)
+		records = append(records, EnergyDayRecord{
+			Date:         day.Format("2006-01-02"),
+			PVGenerated:  pvGenerated,
+			LoadConsumed: loadConsumed,
+		})
+	}
+	return records
+}
+
+func writeEnergyCSV(w io.Writer, records []EnergyDayRecord) error {
+	csvWriter := csv.NewWriter(w)
+	if err := csvWriter.Write([]string{"date", "pv_generated_wh", "load_consumed_wh"}); err != nil {
+		return err
+	}
+	for _, record := range records {
+		row := []string{record.Date, strconv.Itoa(record.PVGenerated), strconv.Itoa(record.LoadConsumed)}
+		if err := csvWriter.Write(row); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+func writeEnergyJSON(w io.Writer, records []EnergyDayRecord) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(records)
+}
+
+// pushEnergyToInflux writes one point per day, stamped at local midnight.
+func pushEnergyToInflux(config InfluxConfig, records []EnergyDayRecord, location *time.Location) error {
+	writer := NewInfluxWriter(config)
+	points := make([]InfluxPoint, 0, len(records))
+	for _, record := range records {
+		day, err := time.ParseInLocation("2006-01-02", record.Date, location)
+		if err != nil {
+			return err
+		}
+		points = append(points, InfluxPoint{
+			Measurement: writer.Measurement("energy_daily"),
+			Tags:        writer.Tags(),
+			Fields: map[string]int{
+				"pv_generated_wh":  record.PVGenerated,
+				"load_consumed_wh": record.LoadConsumed,
+			},
+			Time: day,
+		})
+	}
+	return writer.Write(points)
+}
+
+// runBackfillCommand implements the `backfill` subcommand.
+func runBackfillCommand(args []string) error {
+	fs, devicePtr := newSubcommandFlagSet("backfill")
+	fromPtr := fs.String("from", "", "First day to export (YYYY-MM-DD); defaults to Jan 1 of the earliest year reported by QEY")
+	toPtr := fs.String("to", "", "Last day to export (YYYY-MM-DD); defaults to today")
+	formatPtr := fs.String("format", "csv", "Output format: csv, json or influx (push to the configured time-series sink)")
+	outputPtr := fs.String("output", "", "Output file for csv/json (default energy_backfill.<format>), - for stdout")
+	configPtr := fs.String("config", "/app/mqtt.json", "Config file with the influx sink settings")
+	timezonePtr := fs.String("timezone", "Local", "IANA timezone of the inverter clock")
+	fs.Parse(args)
+
+	if *formatPtr != "csv" && *formatPtr != "json" && *formatPtr != "influx" {
+		return fmt.Errorf("unknown format %q, expected csv, json or influx", *formatPtr)
+	}
+	location, err := time.LoadLocation(*timezonePtr)
+	if err != nil {
+		return fmt.Errorf("invalid timezone %q: %w", *timezonePtr, err)
+	}
+
+	// Check the sink before spending minutes per year of history walking the device
+	var influxConfig InfluxConfig
+	if *formatPtr == "influx" {
+		config, err := LoadMQTTConfig(*configPtr)
+		if err != nil {
+			return err
+		}
+		if !config.Influx.IsEnabled() {
+			return fmt.Errorf("the influx sink is not enabled in %s", *configPtr)
+		}
+		influxConfig = config.Influx
+	}
+
+	today := time.Now().In(location)
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, location)
+	end := today
+	if *toPtr != "" {
+		if end, err = time.ParseInLocation("2006-01-02", *toPtr, location); err != nil {
+			return fmt.Errorf("invalid -to date: %w", err)
+		}
+	}
+
+	communicator, err := openSubcommandDevice(*devicePtr)
+	if err != nil {
+		return err
+	}
+	defer communicator.CloseDevice()
+	parser := NewInverterParser()
+
+	if *formatPtr == "influx" && influxConfig.Device == "" {
+		influxConfig = influxConfig.WithDevice(queryDeviceInfo(communicator, parser))
+	}
+
+	var start time.Time
+	if *fromPtr != "" {
+		if start, err = time.ParseInLocation("2006-01-02", *fromPtr, location); err != nil {
+			return fmt.Errorf("invalid -from date: %w", err)
+		}
+	} else {
+		earliestYear, err := findEarliestEnergyYear(communicator, parser, today)
+		if err != nil {
+			return fmt.Errorf("error probing energy years via QEY: %w", err)
+		}
+		start = time.Date(earliestYear, time.January, 1, 0, 0, 0, 0, location)
+	}
+	if start.After(end) {
+		return fmt.Errorf("start date %s is after end date %s", start.Format("2006-01-02"), end.Format("2006-01-02"))
+	}
+
+	fmt.Printf("Backfill: reading daily energy from %s to %s\n", start.Format("2006-01-02"), end.Format("2006-01-02"))
+	records := backfillEnergyDays(communicator, parser, start, end)
+
+	if *formatPtr == "influx" {
+		if err := pushEnergyToInflux(influxConfig, records, location); err != nil {
+			return err
+		}
+		fmt.Printf("Backfill: pushed %d days to InfluxDB at %s\n", len(records), influxConfig.Host)
+		return nil
+	}
+
+	// Device logs go to stdout as well, so write to a file unless - is asked for explicitly
+	outputPath := *outputPtr
+	if outputPath == "" {
+		outputPath = "energy_backfill." + *formatPtr
+	}
+	output := os.Stdout
+	if outputPath != "-" {
+		file, err := os.Create(outputPath)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", outputPath, err)
+		}
+		defer file.Close()
+		output = file
+	}
+	if *formatPtr == "json" {
+		err = writeEnergyJSON(output, records)
+	} else {
+		err = writeEnergyCSV(output, records)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s output: %w", *formatPtr, err)
+	}
+	fmt.Printf("Backfill: wrote %d days to %s\n", len(records), outputPath)
+	return nil
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// batteryControlStatePath is set by the -battery-control-state flag.
+var batteryControlStatePath = "battery_control.json"
+
+// Switch names of the battery gate, as used on the command line and in MQTT topics.
+const (
+	BatterySwitchDischarge         = "discharge"
+	BatterySwitchChargeFromPV      = "charge_from_pv"
+	BatterySwitchChargeFromUtility = "charge_from_utility"
+)
+
+// batterySwitches lists the switches in the order they are shown.
+var batterySwitches = []string{BatterySwitchDischarge, BatterySwitchChargeFromPV, BatterySwitchChargeFromUtility}
+
+// batterySwitchSetting is the name a switch is allowed under in
+// remote_settings.allowed, e.g. "battery_discharge".
+func batterySwitchSetting(name string) string {
+	return "battery_" + name
+}
+
+// AllowsBatterySwitches reports whether any battery switch may be changed over MQTT.
+func (c RemoteSettingsConfig) AllowsBatterySwitches() bool {
+	for _, name := range batterySwitches {
+		if c.IsAllowed(batterySwitchSetting(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// BatteryControlState is the battery gate last sent. PBATCD switches
+// discharging and the charger as a whole; utility charging is switched through
+// the charger source priority, with "only solar" (PCP03) keeping the charger
+// on PV. The inverter has no query for PBATCD, so the state is kept in a local
+// file; it can be wrong if another tool sends PBATCD.
+type BatteryControlState struct {
+	Discharge         bool `json:"discharge"`
+	ChargeFromPV      bool `json:"charge_from_pv"`
+	ChargeFromUtility bool `json:"charge_from_utility"`
+	// UtilityPriority is the charger source priority replaced by PCP03 when
+	// utility charging was switched off, restored when it is switched back on.
+	UtilityPriority ChargerSourcePriority `json:"utility_priority,omitempty"`
+	Updated         time.Time             `json:"updated,omitempty"`
+	Origin          string                `json:"origin,omitempty"`
+}
+
+// defaultBatteryControlState is the inverter's own behaviour before any PBATCD.
+func defaultBatteryControlState() BatteryControlState {
+	return BatteryControlState{Discharge: true, ChargeFromPV: true, ChargeFromUtility: true}
+}
+
+// Code returns the PBATCD<abc> digits: a=0 would stop discharging and shut the
+// unit down when neither PV nor grid is present, so it stays 1; b=0 stops
+// discharging but keeps standby; c is the charger, on while either source may
+// charge.
+func (st BatteryControlState) Code() string {
+	return "1" + boolDigit(st.Discharge) + boolDigit(st.ChargeFromPV || st.ChargeFromUtility)
+}
+
+// Validate rejects utility-only charging: every charger source priority lets
+// PV charge, so the charger cannot be limited to the grid.
+func (st BatteryControlState) Validate() error {
+	if st.ChargeFromUtility && !st.ChargeFromPV {
+		return fmt.Errorf("%s cannot be on while %s is off: no charger source priority charges from utility only", BatterySwitchChargeFromUtility, BatterySwitchChargeFromPV)
+	}
+	return nil
+}
+
+// SameSwitches reports whether both states have the same switch positions.
+func (st BatteryControlState) SameSwitches(other BatteryControlState) bool {
+	return st.Discharge == other.Discharge && st.ChargeFromPV == other.ChargeFromPV && st.ChargeFromUtility == other.ChargeFromUtility
+}
+
+// Set changes one switch by name.
+func (st *BatteryControlState) Set(name string, on bool) error {
+	switch name {
+	case BatterySwitchDischarge:
+		st.Discharge = on
+	case BatterySwitchChargeFromPV:
+		st.ChargeFromPV = on
+	case BatterySwitchChargeFromUtility:
+		st.ChargeFromUtility = on
+	default:
+		return fmt.Errorf("unknown battery switch %q, expected %s, %s or %s", name, BatterySwitchDischarge, BatterySwitchChargeFromPV, BatterySwitchChargeFromUtility)
+	}
+	return nil
+}
+
+// LoadBatteryControlState reads the state file, or returns the default state
+// when nothing has been sent yet. Switches missing from the file are on.
+func LoadBatteryControlState(path string) (BatteryControlState, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return defaultBatteryControlState(), nil
+	}
+	if err != nil {
+		return BatteryControlState{}, fmt.Errorf("failed to read battery control state %s: %w", path, err)
+	}
+	state := defaultBatteryControlState()
+	if err := json.Unmarshal(content, &state); err != nil {
+		return BatteryControlState{}, fmt.Errorf("failed to unmarshal battery control state %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// SaveBatteryControlState replaces the state file.
+func SaveBatteryControlState(path string, state BatteryControlState) error {
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal battery control state: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write battery control state %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to write battery control state %s: %w", path, err)
+	}
+	return nil
+}
+
+// SetBatteryControl sends the state: first the charger source priority that
+// allows or blocks utility charging, then PBATCD<abc>. The priority is left
+// alone while the charger is off. It records the priority to restore in state.
+func (s *InverterSetter) SetBatteryControl(state *BatteryControlState) error {
+	if err := state.Validate(); err != nil {
+		return err
+	}
+	if state.ChargeFromPV {
+		qpiriData, err := s.queryQPIRI()
+		if err != nil {
+			return err
+		}
+		current := ChargerSourcePriority(qpiriData.ChargerSourcePriority)
+		want := current
+		switch {
+		case !state.ChargeFromUtility && current != ChargerOnlySolar:
+			state.UtilityPriority = current
+			want = ChargerOnlySolar
+		case state.ChargeFromUtility && current == ChargerOnlySolar:
+			want = state.UtilityPriority
+			if want == 0 || want == ChargerOnlySolar {
+				want = ChargerSolarAndUtility
+			}
+			state.UtilityPriority = 0
+		}
+		if want != current {
+			if err := s.SetChargerSourcePriority(want); err != nil {
+				return err
+			}
+		}
+	}
+	return s.sendSetting("PBATCD"+state.Code(), true)
+}
+
+// ReleaseBatteryControl switches everything back on (PBATCD111) and restores
+// the charger source priority that utility charging was switched off from.
+func (s *InverterSetter) ReleaseBatteryControl(state *BatteryControlState) error {
+	released := defaultBatteryControlState()
+	released.UtilityPriority = state.UtilityPriority
+	if err := s.SetBatteryControl(&released); err != nil {
+		return err
+	}
+	*state = released
+	return nil
+}
+
+// batterySwitchRequest is one switch change received over MQTT.
+type batterySwitchRequest struct {
+	name  string
+	on    bool
+	topic string
+}
+
+// BatteryControl exposes the PBATCD switches over MQTT. Commands arrive on
+// <topic>/<devicename>/battery_control/<switch>/set with ON/OFF payloads and
+// are queued, so the polling loop sends them between its own commands; the
+// state is published under battery_control. Only the switches listed in
+// remote_settings.allowed are accepted.
+type BatteryControl struct {
+	config    RemoteSettingsConfig
+	setter    *InverterSetter
+	publisher *MQTTPublisher
+	path      string
+	mu        sync.Mutex
+	pending   []batterySwitchRequest
+}
+
+// NewBatteryControl subscribes to the switch command topics.
+func NewBatteryControl(config RemoteSettingsConfig, setter *InverterSetter, publisher *MQTTPublisher, statePath string) (*BatteryControl, error) {
+	control := &BatteryControl{config: config, setter: setter, publisher: publisher, path: statePath}
+	err := publisher.Subscribe(publisher.Topic("battery_control/+/set"), func(client mqtt.Client, msg mqtt.Message) {
+		name := path.Base(path.Dir(msg.Topic()))
+		var on optionalBool
+		if err := on.Set(strings.ToLower(strings.TrimSpace(string(msg.Payload())))); err != nil {
+			fmt.Printf("Battery control: ignoring %s: %v\n", msg.Topic(), err)
+			return
+		}
+		control.mu.Lock()
+		control.pending = append(control.pending, batterySwitchRequest{name: name, on: on.value, topic: msg.Topic()})
+		control.mu.Unlock()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return control, nil
+}
+
+// Pending reports whether switch changes are waiting to be sent.
+func (c *BatteryControl) Pending() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending) > 0
+}
+
+// ApplyPending folds the queued switch changes into one battery gate change
+// and saves the state once the inverter ACKs it.
+func (c *BatteryControl) ApplyPending() (BatteryControlState, error) {
+	c.mu.Lock()
+	requests := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+
+	current, err := LoadBatteryControlState(c.path)
+	if err != nil {
+		return current, err
+	}
+	next := current
+	var topics []string
+	for _, request := range requests {
+		if !c.config.IsAllowed(batterySwitchSetting(request.name)) {
+			fmt.Printf("Battery control: ignoring %s: %s is not in remote_settings.allowed\n", request.topic, batterySwitchSetting(request.name))
+			continue
+		}
+		if err := next.Set(request.name, request.on); err != nil {
+			fmt.Printf("Battery control: ignoring %s: %v\n", request.topic, err)
+			continue
+		}
+		topics = append(topics, request.topic)
+	}
+	if len(topics) == 0 || next.SameSwitches(current) {
+		return current, nil
+	}
+
+	if err := c.setter.As(AuditOriginMQTT, strings.Join(topics, ",")).SetBatteryControl(&next); err != nil {
+		return current, err
+	}
+	if c.setter.dryRun {
+		return current, nil
+	}
+	next.Updated = time.Now()
+	next.Origin = AuditOriginMQTT
+	return next, SaveBatteryControlState(c.path, next)
+}
+
+// PublishState publishes the state under battery_control.
+func (c *BatteryControl) PublishState() {
+	state, err := LoadBatteryControlState(c.path)
+	if err != nil {
+		fmt.Printf("Error loading battery control state: %v\n", err)
+		return
+	}
+	if err := c.publisher.PublishData(state, "battery_control"); err != nil {
+		fmt.Printf("Error publishing battery control state to MQTT: %v\n", err)
+	}
+}
+
+// runBatteryControl sends the queued switch changes and republishes the state,
+// which also reverts the switches in the UI when the inverter NAKs.
+func runBatteryControl(control *BatteryControl) {
+	fmt.Println("\nSending battery control commands...")
+	state, err := control.ApplyPending()
+	if err != nil {
+		fmt.Printf("Error applying battery control: %v\n", err)
+	} else {
+		fmt.Printf("Battery control state: %+v\n", state)
+	}
+	control.PublishState()
+}
+
+// runBatteryCommand implements the `battery` subcommand. Without flags it
+// prints the locally tracked PBATCD state.
+func runBatteryCommand(args []string) error {
+	fs, devicePtr := newSubcommandFlagSet("battery")
+	var discharge, chargeFromPV, chargeFromUtility optionalBool
+	fs.Var(&discharge, BatterySwitchDischarge, "Allow battery discharging (on/off)")
+	fs.Var(&chargeFromPV, "charge-from-pv", "Allow battery charging from PV (on/off)")
+	fs.Var(&chargeFromUtility, "charge-from-utility", "Allow battery charging from utility (on/off)")
+	releasePtr := fs.Bool("release", false, "Send PBATCD111 and restore the charger source priority, handing charging and discharging back to the inverter settings")
+	fs.StringVar(&batteryControlStatePath, "battery-control-state", batteryControlStatePath, "File tracking the PBATCD state")
+	fs.Parse(args)
+
+	state, err := LoadBatteryControlState(batteryControlStatePath)
+	if err != nil {
+		return err
+	}
+	if !discharge.set && !chargeFromPV.set && !chargeFromUtility.set && !*releasePtr {
+		fmt.Printf("Battery control (PBATCD%s): discharge %s, charge from PV %s, charge from utility %s\n",
+			state.Code(), onOff(state.Discharge), onOff(state.ChargeFromPV), onOff(state.ChargeFromUtility))
+		return nil
+	}
+	if *releasePtr && (discharge.set || chargeFromPV.set || chargeFromUtility.set) {
+		return fmt.Errorf("-release cannot be combined with switch flags")
+	}
+
+	communicator, err := openSubcommandDevice(*devicePtr)
+	if err != nil {
+		return err
+	}
+	defer communicator.CloseDevice()
+	setter := NewInverterSetter(communicator, NewInverterParser())
+
+	if *releasePtr {
+		err = setter.ReleaseBatteryControl(&state)
+	} else {
+		for _, change := range []struct {
+			name string
+			flag optionalBool
+		}{{BatterySwitchDischarge, discharge}, {BatterySwitchChargeFromPV, chargeFromPV}, {BatterySwitchChargeFromUtility, chargeFromUtility}} {
+			if change.flag.set {
+				state.Set(change.name, change.flag.value)
+			}
+		}
+		err = setter.SetBatteryControl(&state)
+	}
+	if err != nil {
+		return err
+	}
+	if setter.dryRun {
+		fmt.Println("\nDry run: nothing was written to the inverter.")
+		return nil
+	}
+	state.Updated = time.Now()
+	state.Origin = AuditOriginCLI
+	if err := SaveBatteryControlState(batteryControlStatePath, state); err != nil {
+		return err
+	}
+	fmt.Printf("\nBattery control set to PBATCD%s: discharge %s, charge from PV %s, charge from utility %s\n",
+		state.Code(), onOff(state.Discharge), onOff(state.ChargeFromPV), onOff(state.ChargeFromUtility))
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BatteryVoltageSetting identifies one of the nn.n battery voltage settings.
+type BatteryVoltageSetting int
+
+const (
+	CutoffVoltage BatteryVoltageSetting = iota
+	RechargeVoltage
+	RedischargeVoltage
+	CVVoltage
+	FloatVoltage
+)
+
+// batteryVoltageRange is the accepted range of a setting on a 48V bank; it is
+// scaled by BatteryRatingVoltage/48 for 12V and 24V models.
+type batteryVoltageRange struct {
+	name     string
+	command  string
+	min, max float64
+}
+
+var batteryVoltageRanges = map[BatteryVoltageSetting]batteryVoltageRange{
+	CutoffVoltage:      {"battery cut-off voltage", "PSDV", 40.0, 48.0},
+	RechargeVoltage:    {"battery re-charge voltage", "PBCV", 44.0, 51.0},
+	RedischargeVoltage: {"battery re-discharge voltage", "PBDV", 48.0, 58.0},
+	CVVoltage:          {"battery C.V. voltage", "PCVV", 48.0, 58.4},
+	FloatVoltage:       {"battery float voltage", "PBFT", 48.0, 58.4},
+}
+
+// referenceBatteryVoltage is the bank voltage batteryVoltageRanges are given for.
+const referenceBatteryVoltage = 48.0
+
+// BatteryVoltagePlan holds the battery voltages as they will be after a change.
+type BatteryVoltagePlan struct {
+	CutoffVoltage      float64
+	RechargeVoltage    float64
+	RedischargeVoltage float64 // 0 means "battery full"
+	CVVoltage          float64
+	FloatVoltage       float64
+}
+
+// NewBatteryVoltagePlan takes the current voltages from QPIRI.
+func NewBatteryVoltagePlan(qpiriData *QPIRIData) *BatteryVoltagePlan {
+	return &BatteryVoltagePlan{
+		CutoffVoltage:      qpiriData.BatteryUnderVoltage,
+		RechargeVoltage:    qpiriData.BatteryRechargeVoltage,
+		RedischargeVoltage: qpiriData.BatteryRedischargeVoltage,
+		CVVoltage:          qpiriData.BatteryBulkVoltage,
+		FloatVoltage:       qpiriData.BatteryFloatVoltage,
+	}
+}
+
+// ApplyTo writes the planned voltages into qpiriData, e.g. to simulate a change.
+func (p *BatteryVoltagePlan) ApplyTo(qpiriData *QPIRIData) {
+	qpiriData.BatteryUnderVoltage = p.CutoffVoltage
+	qpiriData.BatteryRechargeVoltage = p.RechargeVoltage
+	qpiriData.BatteryRedischargeVoltage = p.RedischargeVoltage
+	qpiriData.BatteryBulkVoltage = p.CVVoltage
+	qpiriData.BatteryFloatVoltage = p.FloatVoltage
+}
+
+// Field returns a pointer to the plan value of setting.
+func (p *BatteryVoltagePlan) Field(setting BatteryVoltageSetting) *float64 {
+	switch setting {
+	case CutoffVoltage:
+		return &p.CutoffVoltage
+	case RechargeVoltage:
+		return &p.RechargeVoltage
+	case RedischargeVoltage:
+		return &p.RedischargeVoltage
+	case CVVoltage:
+		return &p.CVVoltage
+	default:
+		return &p.FloatVoltage
+	}
+}
+
+// batteryTypeFixesChargeVoltages reports whether the firmware owns the C.V. and
+// float voltages for the battery type: AGM and Flooded use built-in profiles,
+// the lithium protocol types (Pylontech and later) take them from the BMS.
+func batteryTypeFixesChargeVoltages(batteryType int) bool {
+	return batteryType != int(BatteryUser)
+}
+
+// ValidateBatteryVoltagePlan checks the planned voltages against the per-field
+// ranges of the bank voltage, the battery type and each other. changed lists
+// the settings the caller is about to send; only those are range and type
+// checked, while the ordering rules apply to the whole plan. All violations
+// are reported together.
+func ValidateBatteryVoltagePlan(plan *BatteryVoltagePlan, qpiriData *QPIRIData, changed ...BatteryVoltageSetting) error {
+	var problems []string
+	scale := qpiriData.BatteryRatingVoltage / referenceBatteryVoltage
+
+	for _, setting := range changed {
+		limits := batteryVoltageRanges[setting]
+		volts := *plan.Field(setting)
+		if setting == RedischargeVoltage && volts == 0 {
+			continue
+		}
+		min, max := limits.min*scale, limits.max*scale
+		if volts < min || volts > max {
+			problems = append(problems, fmt.Sprintf("%s %.1fV is outside %.1f-%.1fV for a %.0fV bank",
+				limits.name, volts, min, max, qpiriData.BatteryRatingVoltage))
+		}
+		if (setting == CVVoltage || setting == FloatVoltage) && batteryTypeFixesChargeVoltages(qpiriData.BatteryType) {
+			problems = append(problems, fmt.Sprintf("%s can only be changed with battery type User, but the current type %d fixes it (set battery_type 2 first)",
+				limits.name, qpiriData.BatteryType))
+		}
+	}
+
+	if plan.FloatVoltage > plan.CVVoltage {
+		problems = append(problems, fmt.Sprintf("float voltage %.1fV is above the C.V. voltage %.1fV, so the charger would never leave bulk",
+			plan.FloatVoltage, plan.CVVoltage))
+	}
+	if plan.RechargeVoltage >= plan.FloatVoltage {
+		problems = append(problems, fmt.Sprintf("re-charge voltage %.1fV is not below the float voltage %.1fV, so charging would restart as soon as it stopped",
+			plan.RechargeVoltage, plan.FloatVoltage))
+	}
+	if plan.CutoffVoltage >= plan.RechargeVoltage {
+		problems = append(problems, fmt.Sprintf("cut-off voltage %.1fV is not below the re-charge voltage %.1fV, so the battery would shut down before charging resumes",
+			plan.CutoffVoltage, plan.RechargeVoltage))
+	}
+	if plan.RedischargeVoltage != 0 {
+		if plan.RedischargeVoltage <= plan.RechargeVoltage {
+			problems = append(problems, fmt.Sprintf("re-discharge voltage %.1fV is not above the re-charge voltage %.1fV, so the inverter would toggle between grid and battery",
+				plan.RedischargeVoltage, plan.RechargeVoltage))
+		}
+		if plan.RedischargeVoltage > plan.CVVoltage {
+			problems = append(problems, fmt.Sprintf("re-discharge voltage %.1fV is above the C.V. voltage %.1fV and can never be reached",
+				plan.RedischargeVoltage, plan.CVVoltage))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("inconsistent battery voltages: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
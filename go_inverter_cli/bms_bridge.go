@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// BMSBridgeConfig holds the external BMS bridge settings from the "bms_bridge"
+// section of the config file. Voltage and current limits are used whenever the
+// source does not provide them (serial BMS readers only report SOC and MOS state).
+type BMSBridgeConfig struct {
+	Enabled             string  `json:"enabled"`
+	Source              string  `json:"source"`      // file, mqtt, jk or daly
+	Path                string  `json:"path"`        // JSON file or serial device
+	Topic               string  `json:"topic"`       // MQTT topic for the mqtt source
+	Interval            string  `json:"interval"`    // how often PBMS is sent, e.g. "10s"
+	StaleAfter          string  `json:"stale_after"` // readings older than this stop the bridge, e.g. "60s"
+	CVVoltage           float64 `json:"cv_voltage"`
+	FloatVoltage        float64 `json:"float_voltage"`
+	CutoffVoltage       float64 `json:"cutoff_voltage"`
+	MaxChargeCurrent    int     `json:"max_charge_current"`
+	MaxDischargeCurrent int     `json:"max_discharge_current"`
+}
+
+// IsEnabled reports whether the bridge is switched on ("enabled": "true").
+func (c BMSBridgeConfig) IsEnabled() bool {
+	return strings.EqualFold(c.Enabled, "true")
+}
+
+// BMSMessage is the content of one PBMS frame. The JSON form is what the file
+// and mqtt sources read.
+type BMSMessage struct {
+	Disconnected        bool    `json:"disconnected"`
+	SOC                 int     `json:"soc"`
+	ForceACCharge       bool    `json:"force_ac_charge"`
+	StopDischarge       bool    `json:"stop_discharge"`
+	StopCharge          bool    `json:"stop_charge"`
+	CVVoltage           float64 `json:"cv_voltage"`
+	FloatVoltage        float64 `json:"float_voltage"`
+	CutoffVoltage       float64 `json:"cutoff_voltage"`
+	MaxChargeCurrent    int     `json:"max_charge_current"`
+	MaxDischargeCurrent int     `json:"max_discharge_current"`
+}
+
+// bmsReadingJSON is the payload of the file and mqtt sources: a BMSMessage
+// plus an optional RFC 3339 timestamp of when the BMS was read.
+type bmsReadingJSON struct {
+	BMSMessage
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// withDefaults fills the voltage and current limits the source left at zero.
+func (m BMSMessage) withDefaults(config BMSBridgeConfig) BMSMessage {
+	if m.CVVoltage == 0 {
+		m.CVVoltage = config.CVVoltage
+	}
+	if m.FloatVoltage == 0 {
+		m.FloatVoltage = config.FloatVoltage
+	}
+	if m.CutoffVoltage == 0 {
+		m.CutoffVoltage = config.CutoffVoltage
+	}
+	if m.MaxChargeCurrent == 0 {
+		m.MaxChargeCurrent = config.MaxChargeCurrent
+	}
+	if m.MaxDischargeCurrent == 0 {
+		m.MaxDischargeCurrent = config.MaxDischargeCurrent
+	}
+	return m
+}
+
+// Command formats the message as a PBMS command. The voltages go out in 0.1 V
+// (560 = 56.0 V); whole volts in three digits could not carry a float voltage.
+func (m BMSMessage) Command() (string, error) {
+	if err := validateIntRange("BMS SOC", m.SOC, 0, 100, 1); err != nil {
+		return "", err
+	}
+	for _, voltage := range []struct {
+		name  string
+		value float64
+	}{{"BMS C.V. voltage", m.CVVoltage}, {"BMS float voltage", m.FloatVoltage}, {"BMS cut-off voltage", m.CutoffVoltage}} {
+		if err := validateFloatRange(voltage.name, voltage.value, 0.1, 99.9); err != nil {
+			return "", err
+		}
+	}
+	if m.FloatVoltage > m.CVVoltage || m.CutoffVoltage >= m.FloatVoltage {
+		return "", fmt.Errorf("BMS voltages out of order: cut-off %.1fV, float %.1fV, C.V. %.1fV", m.CutoffVoltage, m.FloatVoltage, m.CVVoltage)
+	}
+	if err := validateIntRange("BMS max charging current", m.MaxChargeCurrent, 0, 9999, 1); err != nil {
+		return "", err
+	}
+	if err := validateIntRange("BMS max discharging current", m.MaxDischargeCurrent, 0, 9999, 1); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("PBMS%s %03d %s %s %s %03d %03d %03d %04d %04d",
+		boolDigit(m.Disconnected), m.SOC, boolDigit(m.ForceACCharge), boolDigit(m.StopDischarge), boolDigit(m.StopCharge),
+		int(math.Round(m.CVVoltage*10)), int(math.Round(m.FloatVoltage*10)), int(math.Round(m.CutoffVoltage*10)),
+		m.MaxChargeCurrent, m.MaxDischargeCurrent), nil
+}
+
+// SendBMSMessage sends a PBMS frame to the inverter and returns its command.
+// PBMS repeats every bridge interval, so a frame equal to previous is sent
+// without a new audit log entry.
+func (s *InverterSetter) SendBMSMessage(message BMSMessage, previous string) (string, error) {
+	command, err := message.Command()
+	if err != nil {
+		return "", err
+	}
+	setter := s
+	if command == previous {
+		repeated := *s
+		repeated.skipRecord = true
+		setter = &repeated
+	}
+	return command, setter.changeSetting(command, true, nil)
+}
+
+// BMSSource provides the latest BMS reading and when it was taken.
+type BMSSource interface {
+	Read() (BMSMessage, time.Time, error)
+}
+
+// fileBMSSource reads a JSON file written by another process. Without a
+// timestamp in the file, its modification time dates the reading.
+type fileBMSSource struct {
+	path string
+}
+
+func (s *fileBMSSource) Read() (BMSMessage, time.Time, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return BMSMessage{}, time.Time{}, fmt.Errorf("failed to stat BMS file %s: %w", s.path, err)
+	}
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return BMSMessage{}, time.Time{}, fmt.Errorf("failed to read BMS file %s: %w", s.path, err)
+	}
+	var reading bmsReadingJSON
+	if err := json.Unmarshal(content, &reading); err != nil {
+		return BMSMessage{}, time.Time{}, fmt.Errorf("failed to unmarshal BMS file %s: %w", s.path, err)
+	}
+	if reading.Timestamp.IsZero() {
+		reading.Timestamp = info.ModTime()
+	}
+	return reading.BMSMessage, reading.Timestamp, nil
+}
+
+// mqttBMSSource keeps the last JSON message received on a topic. Without a
+// timestamp in the payload, the time of arrival dates the reading.
+type mqttBMSSource struct {
+	topic    string
+	mu       sync.Mutex
+	message  BMSMessage
+	received time.Time
+	err      error
+}
+
+func newMQTTBMSSource(publisher *MQTTPublisher, topic string) (*mqttBMSSource, error) {
+	source := &mqttBMSSource{topic: topic}
+	err := publisher.Subscribe(topic, func(client mqtt.Client, msg mqtt.Message) {
+		var reading bmsReadingJSON
+		err := json.Unmarshal(msg.Payload(), &reading)
+		source.mu.Lock()
+		defer source.mu.Unlock()
+		if err != nil {
+			source.err = fmt.Errorf("failed to unmarshal BMS message on %s: %w", topic, err)
+			return
+		}
+		if reading.Timestamp.IsZero() {
+			reading.Timestamp = time.Now()
+		}
+		source.message = reading.BMSMessage
+		source.received = reading.Timestamp
+		source.err = nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+func (s *mqttBMSSource) Read() (BMSMessage, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return BMSMessage{}, time.Time{}, s.err
+	}
+	if s.received.IsZero() {
+		return BMSMessage{}, time.Time{}, fmt.Errorf("no BMS message received on %s yet", s.topic)
+	}
+	return s.message, s.received, nil
+}
+
+// newBMSSource creates the source named in the config.
+func newBMSSource(config BMSBridgeConfig, publisher *MQTTPublisher) (BMSSource, error) {
+	switch config.Source {
+	case "file":
+		return &fileBMSSource{path: config.Path}, nil
+	case "mqtt":
+		if config.Topic == "" {
+			return nil, fmt.Errorf("the mqtt BMS source needs a topic")
+		}
+		return newMQTTBMSSource(publisher, config.Topic)
+	case "jk":
+		return &serialBMSSource{path: config.Path, reader: readJKBMS}, nil
+	case "daly":
+		return &serialBMSSource{path: config.Path, reader: readDalyBMS}, nil
+	default:
+		return nil, fmt.Errorf("unknown BMS source %q, expected file, mqtt, jk or daly", config.Source)
+	}
+}
+
+// BMSBridgeStatus is published under bms_bridge after every bridge cycle.
+type BMSBridgeStatus struct {
+	Source            string
+	SOC               int
+	ReadingAgeSeconds float64
+	Stale             bool
+	Sent              bool
+	Error             string
+}
+
+// BMSBridge periodically forwards readings from an external BMS to the
+// inverter via PBMS. Its watchdog stops sending once the readings go stale,
+// so the inverter falls back to its own voltage-based battery handling.
+type BMSBridge struct {
+	config     BMSBridgeConfig
+	source     BMSSource
+	setter     *InverterSetter
+	interval   time.Duration
+	staleAfter time.Duration
+	lastRun    time.Time
+	stale      bool
+	// lastCommand is the PBMS frame last sent since the bridge turned fresh,
+	// so the audit log records changes and not every repeat
+	lastCommand string
+}
+
+// NewBMSBridge validates the config and creates its source.
+func NewBMSBridge(config BMSBridgeConfig, setter *InverterSetter, publisher *MQTTPublisher) (*BMSBridge, error) {
+	interval, err := time.ParseDuration(config.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BMS bridge interval %q: %w", config.Interval, err)
+	}
+	staleAfter, err := time.ParseDuration(config.StaleAfter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BMS bridge stale_after %q: %w", config.StaleAfter, err)
+	}
+	if staleAfter < interval {
+		return nil, fmt.Errorf("BMS bridge stale_after (%s) must not be shorter than interval (%s)", staleAfter, interval)
+	}
+	source, err := newBMSSource(config, publisher)
+	if err != nil {
+		return nil, err
+	}
+	return &BMSBridge{
+		config:     config,
+		source:     source,
+		setter:     setter,
+		interval:   interval,
+		staleAfter: staleAfter,
+		stale:      true, // nothing has been sent yet
+	}, nil
+}
+
+// Due reports whether the next PBMS frame should be sent.
+func (b *BMSBridge) Due() bool {
+	return time.Since(b.lastRun) >= b.interval
+}
+
+// Run reads the source once and, unless the reading is stale, sends it.
+func (b *BMSBridge) Run() *BMSBridgeStatus {
+	b.lastRun = time.Now()
+	status := &BMSBridgeStatus{Source: b.config.Source}
+
+	message, readAt, err := b.source.Read()
+	if err != nil {
+		status.Error = err.Error()
+		status.Stale = true
+		b.setStale(true, err.Error())
+		return status
+	}
+	status.SOC = message.SOC
+	age := time.Since(readAt)
+	status.ReadingAgeSeconds = age.Seconds()
+	if age > b.staleAfter {
+		status.Stale = true
+		b.setStale(true, fmt.Sprintf("last reading is %s old", age.Round(time.Second)))
+		return status
+	}
+	b.setStale(false, "")
+
+	command, err := b.setter.SendBMSMessage(message.withDefaults(b.config), b.lastCommand)
+	if command != "" {
+		b.lastCommand = command
+	}
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Sent = true
+	return status
+}
+
+// setStale logs watchdog transitions only, not every stale cycle.
+func (b *BMSBridge) setStale(stale bool, reason string) {
+	if stale == b.stale {
+		return
+	}
+	b.stale = stale
+	b.lastCommand = "" // the next frame is recorded
+	if stale {
+		fmt.Printf("BMS bridge: %s source went stale (%s), no longer sending PBMS\n", b.config.Source, reason)
+	} else {
+		fmt.Printf("BMS bridge: %s source is fresh, sending PBMS\n", b.config.Source)
+	}
+}
+
+// runBMSBridge runs one bridge cycle and publishes its status under bms_bridge.
+func runBMSBridge(bridge *BMSBridge, publisher *MQTTPublisher) {
+	fmt.Println("\nRunning BMS bridge...")
+	status := bridge.Run()
+	fmt.Printf("BMS Bridge Status: %+v\n", status)
+	err := publisher.PublishData(status, "bms_bridge")
+	if err != nil {
+		fmt.Printf("Error publishing BMS bridge status to MQTT: %v\n", err)
+	}
+}
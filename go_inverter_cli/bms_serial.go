@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// serialBMSTimeout bounds one request/response exchange with a serial BMS.
+const serialBMSTimeout = 2 * time.Second
+
+// serialBMSSource polls a BMS over a serial port. The port is opened for every
+// read so a USB adapter that re-enumerates is picked up again.
+type serialBMSSource struct {
+	path   string
+	reader func(port *os.File) (BMSMessage, error)
+}
+
+func (s *serialBMSSource) Read() (BMSMessage, time.Time, error) {
+	port, err := openSerialPort(s.path)
+	if err != nil {
+		return BMSMessage{}, time.Time{}, err
+	}
+	defer port.Close()
+
+	message, err := s.reader(port)
+	if err != nil {
+		return BMSMessage{}, time.Time{}, fmt.Errorf("error reading BMS on %s: %w", s.path, err)
+	}
+	return message, time.Now(), nil
+}
+
+// serialExchange writes a request and reads exactly len(response) bytes back.
+func serialExchange(port *os.File, request []byte, response []byte) error {
+	port.SetDeadline(time.Now().Add(serialBMSTimeout))
+	if _, err := port.Write(request); err != nil {
+		return fmt.Errorf("error writing request: %w", err)
+	}
+	if _, err := io.ReadFull(port, response); err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+	return nil
+}
+
+// Daly UART protocol: 13-byte frames A5 <addr> <cmd> 08 <8 data bytes> <sum>.
+const (
+	dalyStartByte     = 0xA5
+	dalyHostAddress   = 0x40
+	dalyBMSAddress    = 0x01
+	dalySOCCommand    = 0x90 // total voltage, current, SOC (0.1%)
+	dalyMOSCommand    = 0x93 // charge/discharge MOS state
+	dalyFrameLength   = 13
+	dalyDataLength    = 8
+	dalyDataOffset    = 4
+	dalyChecksumIndex = dalyFrameLength - 1
+)
+
+func dalyChecksum(frame []byte) byte {
+	var sum byte
+	for _, b := range frame {
+		sum += b
+	}
+	return sum
+}
+
+// dalyQuery sends one Daly command and returns its 8 data bytes.
+func dalyQuery(port *os.File, command byte) ([]byte, error) {
+	request := make([]byte, dalyFrameLength)
+	request[0] = dalyStartByte
+	request[1] = dalyHostAddress
+	request[2] = command
+	request[3] = dalyDataLength
+	request[dalyChecksumIndex] = dalyChecksum(request[:dalyChecksumIndex])
+
+	response := make([]byte, dalyFrameLength)
+	if err := serialExchange(port, request, response); err != nil {
+		return nil, err
+	}
+	if response[0] != dalyStartByte || response[1] != dalyBMSAddress || response[2] != command {
+		return nil, fmt.Errorf("unexpected Daly frame % X", response)
+	}
+	if response[dalyChecksumIndex] != dalyChecksum(response[:dalyChecksumIndex]) {
+		return nil, fmt.Errorf("Daly checksum mismatch in frame % X", response)
+	}
+	return response[dalyDataOffset : dalyDataOffset+dalyDataLength], nil
+}
+
+// readDalyBMS reads SOC (0x90) and the MOS state (0x93) from a Daly BMS. A BMS
+// that opened its charge or discharge MOS stops that direction on the inverter.
+func readDalyBMS(port *os.File) (BMSMessage, error) {
+	socData, err := dalyQuery(port, dalySOCCommand)
+	if err != nil {
+		return BMSMessage{}, err
+	}
+	mosData, err := dalyQuery(port, dalyMOSCommand)
+	if err != nil {
+		return BMSMessage{}, err
+	}
+	return BMSMessage{
+		SOC:           (int(binary.BigEndian.Uint16(socData[6:8])) + 5) / 10,
+		StopCharge:    mosData[1] == 0,
+		StopDischarge: mosData[2] == 0,
+	}, nil
+}
+
+// JK BMS RS485/TTL protocol: 4E 57 <len:2> <terminal:4> <cmd> <source>
+// <transport> <data...> <record:4> 68 <checksum:4>, where len counts from the
+// length field to the end and the checksum sums every byte before it.
+const (
+	jkHeaderLength  = 11
+	jkTrailerLength = 9
+	jkReadAll       = 0x06
+	jkEndFlag       = 0x68
+	jkCellVoltages  = 0x79 // followed by a length byte
+	jkSOC           = 0x85
+	jkStatus        = 0x8C // bit 0: charge MOS on, bit 1: discharge MOS on
+)
+
+// jkFieldLengths holds the data length of the fixed-size fields in front of
+// the status field; parsing stops at the first field not listed.
+var jkFieldLengths = map[byte]int{
+	0x80: 2, 0x81: 2, 0x82: 2, 0x83: 2, 0x84: 2,
+	jkSOC: 1, 0x86: 1, 0x87: 2, 0x89: 4, 0x8A: 2, 0x8B: 2, jkStatus: 2,
+}
+
+func jkChecksum(frame []byte) uint32 {
+	var sum uint32
+	for _, b := range frame {
+		sum += uint32(b)
+	}
+	return sum
+}
+
+// readJKBMS reads SOC and the MOS state from a JK BMS with a read-all request.
+func readJKBMS(port *os.File) (BMSMessage, error) {
+	request := []byte{0x4E, 0x57, 0x00, 0x13, 0x00, 0x00, 0x00, 0x00, jkReadAll, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, jkEndFlag, 0x00, 0x00, 0x00, 0x00}
+	binary.BigEndian.PutUint32(request[len(request)-4:], jkChecksum(request[:len(request)-4]))
+
+	header := make([]byte, 4)
+	if err := serialExchange(port, request, header); err != nil {
+		return BMSMessage{}, err
+	}
+	if header[0] != 0x4E || header[1] != 0x57 {
+		return BMSMessage{}, fmt.Errorf("unexpected JK frame start % X", header)
+	}
+	length := int(binary.BigEndian.Uint16(header[2:4]))
+	if length < jkHeaderLength+jkTrailerLength-2 {
+		return BMSMessage{}, fmt.Errorf("JK frame too short: %d", length)
+	}
+	frame := make([]byte, length+2)
+	copy(frame, header)
+	if _, err := io.ReadFull(port, frame[4:]); err != nil {
+		return BMSMessage{}, fmt.Errorf("error reading response: %w", err)
+	}
+	if binary.BigEndian.Uint32(frame[len(frame)-4:]) != jkChecksum(frame[:len(frame)-4]) {
+		return BMSMessage{}, fmt.Errorf("JK checksum mismatch")
+	}
+
+	message := BMSMessage{}
+	socFound := false
+	data := frame[jkHeaderLength : len(frame)-jkTrailerLength]
+	for i := 0; i < len(data); {
+		id := data[i]
+		i++
+		var fieldLength int
+		if id == jkCellVoltages {
+			if i >= len(data) {
+				break
+			}
+			fieldLength = int(data[i])
+			i++
+		} else if known, ok := jkFieldLengths[id]; ok {
+			fieldLength = known
+		} else {
+			break
+		}
+		if i+fieldLength > len(data) {
+			break
+		}
+		field := data[i : i+fieldLength]
+		i += fieldLength
+
+		switch id {
+		case jkSOC:
+			message.SOC = int(field[0])
+			socFound = true
+		case jkStatus:
+			status := binary.BigEndian.Uint16(field)
+			message.StopCharge = status&0x01 == 0
+			message.StopDischarge = status&0x02 == 0
+		}
+		if id == jkStatus {
+			break
+		}
+	}
+	if !socFound {
+		return BMSMessage{}, fmt.Errorf("JK frame carries no SOC field")
+	}
+	return message, nil
+}
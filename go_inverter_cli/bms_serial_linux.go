@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// ioctlTCFLSH is TCFLSH on x86/arm Linux; package syscall does not export it.
+const ioctlTCFLSH = 0x540B
+
+// openSerialPort opens a tty in raw 9600 8N1 mode, which both JK and Daly use.
+func openSerialPort(path string) (*os.File, error) {
+	port, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error opening serial port %s: %w", path, err)
+	}
+
+	// Go through SyscallConn rather than Fd() so the port stays non-blocking and read deadlines work
+	rawConn, err := port.SyscallConn()
+	if err != nil {
+		port.Close()
+		return nil, fmt.Errorf("error configuring serial port %s: %w", path, err)
+	}
+	var ioctlErr syscall.Errno
+	err = rawConn.Control(func(fd uintptr) {
+		termios := syscall.Termios{
+			Cflag:  syscall.B9600 | syscall.CS8 | syscall.CREAD | syscall.CLOCAL,
+			Ispeed: syscall.B9600,
+			Ospeed: syscall.B9600,
+		}
+		termios.Cc[syscall.VMIN] = 1
+		_, _, ioctlErr = syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(syscall.TCSETS), uintptr(unsafe.Pointer(&termios)))
+		if ioctlErr == 0 {
+			_, _, ioctlErr = syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlTCFLSH, uintptr(syscall.TCIFLUSH))
+		}
+	})
+	if err == nil && ioctlErr != 0 {
+		err = ioctlErr
+	}
+	if err != nil {
+		port.Close()
+		return nil, fmt.Errorf("error configuring serial port %s: %w", path, err)
+	}
+	return port, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// openSerialPort is only implemented for Linux, where the BMS bridge runs.
+func openSerialPort(path string) (*os.File, error) {
+	return nil, fmt.Errorf("serial BMS sources are not supported on %s (port %s)", runtime.GOOS, path)
+}
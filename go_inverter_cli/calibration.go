@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Battery voltage calibration limits.
+const (
+	calibrationMinSpan        = 1.0 // volts between the two calibration points
+	calibrationMaxOffsetRatio = 0.1 // meter and inverter may differ by at most 10% of the rating
+	calibrationMaxVolts       = 999.99
+	calibrationSettleTime     = 5 * time.Second // before verifying the new calibration
+	calibrationSampleInterval = 1 * time.Second
+)
+
+// VoltageMeter provides the reference battery voltage from an external meter
+// and when it was measured.
+type VoltageMeter interface {
+	Read() (float64, time.Time, error)
+}
+
+// parseMeterReading accepts a plain number ("52.30") or {"voltage": 52.30}.
+func parseMeterReading(content []byte) (float64, error) {
+	text := strings.TrimSpace(string(content))
+	if volts, err := strconv.ParseFloat(text, 64); err == nil {
+		return volts, nil
+	}
+	var reading struct {
+		Voltage *float64 `json:"voltage"`
+	}
+	if err := json.Unmarshal([]byte(text), &reading); err != nil || reading.Voltage == nil {
+		return 0, fmt.Errorf("expected a voltage or {\"voltage\": ...}, got %q", text)
+	}
+	return *reading.Voltage, nil
+}
+
+// promptVoltageMeter asks the operator to type the meter reading.
+type promptVoltageMeter struct {
+	in *bufio.Reader
+}
+
+func (m *promptVoltageMeter) Read() (float64, time.Time, error) {
+	fmt.Print("Enter the meter reading in V: ")
+	line, err := m.in.ReadString('\n')
+	if err != nil && line == "" {
+		return 0, time.Time{}, fmt.Errorf("no meter reading entered")
+	}
+	volts, err := parseMeterReading([]byte(line))
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return volts, time.Now(), nil
+}
+
+// fileVoltageMeter reads a file written by the meter; its modification time
+// dates the reading.
+type fileVoltageMeter struct {
+	path string
+}
+
+func (m *fileVoltageMeter) Read() (float64, time.Time, error) {
+	info, err := os.Stat(m.path)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to stat meter file %s: %w", m.path, err)
+	}
+	content, err := os.ReadFile(m.path)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to read meter file %s: %w", m.path, err)
+	}
+	volts, err := parseMeterReading(content)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("invalid meter file %s: %w", m.path, err)
+	}
+	return volts, info.ModTime(), nil
+}
+
+// mqttVoltageMeter keeps the last reading received on a topic.
+type mqttVoltageMeter struct {
+	topic    string
+	mu       sync.Mutex
+	volts    float64
+	received time.Time
+	err      error
+}
+
+func newMQTTVoltageMeter(publisher *MQTTPublisher, topic string) (*mqttVoltageMeter, error) {
+	meter := &mqttVoltageMeter{topic: topic}
+	err := publisher.Subscribe(topic, func(client mqtt.Client, msg mqtt.Message) {
+		volts, err := parseMeterReading(msg.Payload())
+		meter.mu.Lock()
+		defer meter.mu.Unlock()
+		if err != nil {
+			meter.err = fmt.Errorf("invalid meter reading on %s: %w", topic, err)
+			return
+		}
+		meter.volts = volts
+		meter.received = time.Now()
+		meter.err = nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return meter, nil
+}
+
+func (m *mqttVoltageMeter) Read() (float64, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return 0, time.Time{}, m.err
+	}
+	if m.received.IsZero() {
+		return 0, time.Time{}, fmt.Errorf("no meter reading received on %s yet", m.topic)
+	}
+	return m.volts, m.received, nil
+}
+
+// calibrationPoint pairs the inverter's battery voltage with the meter's.
+type calibrationPoint struct {
+	Inverter float64
+	Meter    float64
+}
+
+func (p calibrationPoint) Offset() float64 {
+	return p.Meter - p.Inverter
+}
+
+// measureCalibrationPoint averages samples QPIGS battery voltages and reads
+// the meter once after them. Readings older than maxAge are rejected, as are
+// meter values too far from the inverter to be the same battery.
+func measureCalibrationPoint(setter *InverterSetter, meter VoltageMeter, samples int, maxAge time.Duration, ratingVoltage float64) (calibrationPoint, error) {
+	var sum float64
+	for i := 0; i < samples; i++ {
+		if i > 0 {
+			time.Sleep(calibrationSampleInterval)
+		}
+		qpigsData, err := setter.queryQPIGS()
+		if err != nil {
+			return calibrationPoint{}, err
+		}
+		sum += qpigsData.BatteryVoltage
+	}
+	point := calibrationPoint{Inverter: sum / float64(samples)}
+
+	volts, readAt, err := meter.Read()
+	if err != nil {
+		return calibrationPoint{}, err
+	}
+	if age := time.Since(readAt); age > maxAge {
+		return calibrationPoint{}, fmt.Errorf("the meter reading is %s old, more than -max-age %s", age.Round(time.Second), maxAge)
+	}
+	if err := validateFloatRange("meter reading", volts, 0.01, calibrationMaxVolts); err != nil {
+		return calibrationPoint{}, err
+	}
+	point.Meter = volts
+	if math.Abs(point.Offset()) > ratingVoltage*calibrationMaxOffsetRatio {
+		return calibrationPoint{}, fmt.Errorf("the meter reads %.2fV but the inverter %.2fV, check that the meter is on the battery terminals", point.Meter, point.Inverter)
+	}
+	return point, nil
+}
+
+// SetBatteryVoltageAdjustPoint sends the reference voltage of calibration
+// point one (BTA1<nnn.nn>) or two (BTA2<nnn.nn>).
+func (s *InverterSetter) SetBatteryVoltageAdjustPoint(point int, volts float64) error {
+	if err := validateIntRange("calibration point", point, 1, 2, 1); err != nil {
+		return err
+	}
+	if err := validateFloatRange("calibration voltage", volts, 0.01, calibrationMaxVolts); err != nil {
+		return err
+	}
+	return s.sendSetting(fmt.Sprintf("BTA%d%06.2f", point, volts), true)
+}
+
+// newVoltageMeter creates the meter named by -source.
+func newVoltageMeter(source, path, topic, configPath string, stdin *bufio.Reader) (VoltageMeter, func(), error) {
+	switch source {
+	case "prompt":
+		return &promptVoltageMeter{in: stdin}, func() {}, nil
+	case "file":
+		if path == "" {
+			return nil, nil, fmt.Errorf("the file meter source needs -path")
+		}
+		return &fileVoltageMeter{path: path}, func() {}, nil
+	case "mqtt":
+		if topic == "" {
+			return nil, nil, fmt.Errorf("the mqtt meter source needs -topic")
+		}
+		config, err := LoadMQTTConfig(configPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		publisher := NewMQTTPublisher(config)
+		if err := publisher.Connect(); err != nil {
+			return nil, nil, err
+		}
+		meter, err := newMQTTVoltageMeter(publisher, topic)
+		if err != nil {
+			publisher.Disconnect()
+			return nil, nil, err
+		}
+		return meter, publisher.Disconnect, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown meter source %q, expected prompt, file or mqtt", source)
+	}
+}
+
+// runCalibrateCommand implements the `calibrate` subcommand: it measures two
+// points with the battery at different voltages, sends BTA1/BTA2 with the
+// meter readings and checks the result against fresh QPIGS readings. A failed
+// calibration is undone with BTA0, which therefore has to be allowed under
+// destructive_commands unless -no-rollback is given.
+func runCalibrateCommand(args []string) error {
+	fs, devicePtr := newSubcommandFlagSet("calibrate")
+	sourcePtr := fs.String("source", "prompt", "Reference meter: prompt (type the reading), file or mqtt")
+	pathPtr := fs.String("path", "", "File holding the meter reading for -source file")
+	topicPtr := fs.String("topic", "", "MQTT topic carrying the meter reading for -source mqtt")
+	configPtr := fs.String("config", "/app/mqtt.json", "Config file with the MQTT broker and destructive_commands settings")
+	samplesPtr := fs.Int("samples", 5, "QPIGS readings averaged per point")
+	maxAgePtr := fs.Duration("max-age", 30*time.Second, "Reject meter readings older than this")
+	tolerancePtr := fs.Float64("tolerance", 0.1, "Accepted difference between inverter and meter after calibrating, V")
+	noRollbackPtr := fs.Bool("no-rollback", false, "Keep a calibration that fails verification instead of sending BTA0")
+	fs.Parse(args)
+
+	if *samplesPtr < 1 {
+		return fmt.Errorf("-samples must be at least 1")
+	}
+	config, err := LoadMQTTConfig(*configPtr)
+	if err != nil && !*noRollbackPtr {
+		return fmt.Errorf("%w; the config decides whether BTA0 may roll back a failed calibration, pass -no-rollback to calibrate without it", err)
+	}
+	if !*noRollbackPtr && !config.DestructiveCommands.IsAllowed("BTA0") {
+		return fmt.Errorf("rolling back a failed calibration needs BTA0 in destructive_commands.allowed in %s, or pass -no-rollback", *configPtr)
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	meter, closeMeter, err := newVoltageMeter(*sourcePtr, *pathPtr, *topicPtr, *configPtr, stdin)
+	if err != nil {
+		return err
+	}
+	defer closeMeter()
+
+	communicator, err := openSubcommandDevice(*devicePtr)
+	if err != nil {
+		return err
+	}
+	defer communicator.CloseDevice()
+	setter := NewInverterSetter(communicator, NewInverterParser())
+
+	qpiriData, err := setter.queryQPIRI()
+	if err != nil {
+		return err
+	}
+
+	sent := false
+	rollback := func(reason error) error {
+		if !sent || *noRollbackPtr || setter.dryRun {
+			return reason
+		}
+		fmt.Printf("\nCalibration failed (%v), rolling back with BTA0...\n", reason)
+		if err := setter.RunDestructiveCommand(destructiveCommands["bta0"]); err != nil {
+			return fmt.Errorf("%v; the BTA0 rollback failed too: %w", reason, err)
+		}
+		return fmt.Errorf("%w; the calibration was rolled back with BTA0", reason)
+	}
+
+	var points [2]calibrationPoint
+	for i := range points {
+		fmt.Printf("\nCalibration point %d: ", i+1)
+		if i == 0 {
+			fmt.Println("keep the battery at a steady voltage, e.g. resting or float.")
+		} else {
+			fmt.Printf("move the battery at least %.1fV away from %.2fV, e.g. by starting or stopping the charge, and let it settle.\n", calibrationMinSpan, points[0].Meter)
+			fmt.Print("Press Enter when ready. ")
+			stdin.ReadString('\n')
+		}
+
+		point, err := measureCalibrationPoint(setter, meter, *samplesPtr, *maxAgePtr, qpiriData.BatteryRatingVoltage)
+		if err != nil {
+			return rollback(err)
+		}
+		if i == 1 && math.Abs(point.Meter-points[0].Meter) < calibrationMinSpan {
+			return rollback(fmt.Errorf("the two points are only %.2fV apart, they need at least %.1fV", math.Abs(point.Meter-points[0].Meter), calibrationMinSpan))
+		}
+		points[i] = point
+		fmt.Printf("Inverter %.2fV, meter %.2fV (offset %+.2fV)\n", point.Inverter, point.Meter, point.Offset())
+
+		if err := setter.SetBatteryVoltageAdjustPoint(i+1, point.Meter); err != nil {
+			return rollback(err)
+		}
+		sent = true
+	}
+	if setter.dryRun {
+		fmt.Println("\nDry run: nothing was written to the inverter.")
+		return nil
+	}
+
+	fmt.Printf("\nVerifying against QPIGS in %s...\n", calibrationSettleTime)
+	time.Sleep(calibrationSettleTime)
+	check, err := measureCalibrationPoint(setter, meter, *samplesPtr, *maxAgePtr, qpiriData.BatteryRatingVoltage)
+	if err != nil {
+		return rollback(err)
+	}
+	fmt.Printf("Inverter %.2fV, meter %.2fV (offset %+.2fV)\n", check.Inverter, check.Meter, check.Offset())
+	if math.Abs(check.Offset()) > *tolerancePtr {
+		return rollback(fmt.Errorf("the inverter still reads %+.2fV off the meter, more than -tolerance %.2fV", -check.Offset(), *tolerancePtr))
+	}
+	fmt.Println("\nBattery voltage calibration applied and verified. Undo it with: reset bta0")
+	return nil
+}
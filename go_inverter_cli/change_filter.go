@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChangeFilterConfig holds the "change_filter" section of the config file.
+type ChangeFilterConfig struct {
+	Enabled   string            `json:"enabled"`
+	Heartbeat string            `json:"heartbeat"` // longest silence per topic, e.g. "5m"; empty for none
+	Deadbands map[string]string `json:"deadbands"` // field (or "<subtopic>/<field>") to "1" (absolute) or "2%"
+}
+
+// IsEnabled reports whether change detection is switched on ("enabled": "true").
+func (c ChangeFilterConfig) IsEnabled() bool {
+	return strings.EqualFold(c.Enabled, "true")
+}
+
+// deadband is how far a numeric field may move from its last published value
+// before it counts as changed.
+type deadband struct {
+	value   float64
+	percent bool // value is a percentage of the last published value
+}
+
+// parseDeadband parses "1", "0.5" or "2%".
+func parseDeadband(text string) (deadband, error) {
+	text = strings.TrimSpace(text)
+	percent := strings.HasSuffix(text, "%")
+	value, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(text, "%")), 64)
+	if err != nil || value < 0 {
+		return deadband{}, fmt.Errorf("invalid deadband %q, expected e.g. \"1\" or \"2%%\"", text)
+	}
+	return deadband{value: value, percent: percent}, nil
+}
+
+// exceeded reports whether current is outside the band around last.
+func (d deadband) exceeded(last, current float64) bool {
+	limit := d.value
+	if d.percent {
+		limit = math.Abs(last) * d.value / 100
+	}
+	return math.Abs(current-last) > limit
+}
+
+// sentMessage is what was last published on a topic.
+type sentMessage struct {
+	at     time.Time
+	fields map[string]json.RawMessage
+}
+
+// ChangeFilter suppresses messages whose fields have not changed since they
+// were last published on the same topic, unless the heartbeat has elapsed.
+// Numeric fields with a deadband must move by more than the band; all other
+// fields must differ.
+type ChangeFilter struct {
+	heartbeat time.Duration
+	deadbands map[string]deadband
+	mu        sync.Mutex
+	sent      map[string]sentMessage // by absolute topic
+}
+
+// NewChangeFilter parses the heartbeat and the deadbands.
+func NewChangeFilter(config ChangeFilterConfig) (*ChangeFilter, error) {
+	filter := &ChangeFilter{
+		deadbands: make(map[string]deadband),
+		sent:      make(map[string]sentMessage),
+	}
+	if config.Heartbeat != "" {
+		heartbeat, err := time.ParseDuration(config.Heartbeat)
+		if err != nil {
+			return nil, fmt.Errorf("invalid change_filter heartbeat %q: %w", config.Heartbeat, err)
+		}
+		if heartbeat <= 0 {
+			return nil, fmt.Errorf("change_filter.heartbeat must be positive, got %s", heartbeat)
+		}
+		filter.heartbeat = heartbeat
+	}
+	for field, text := range config.Deadbands {
+		band, err := parseDeadband(text)
+		if err != nil {
+			return nil, fmt.Errorf("change_filter.deadbands.%s: %w", field, err)
+		}
+		filter.deadbands[field] = band
+	}
+	return filter, nil
+}
+
+// deadbandFor looks up the deadband of a field like the namingMap: a
+// "<subtopic>/<field>" entry takes precedence over a plain "<field>" entry.
+func (f *ChangeFilter) deadbandFor(subTopic, field string) (deadband, bool) {
+	if band, ok := f.deadbands[subTopic+"/"+field]; ok {
+		return band, true
+	}
+	band, ok := f.deadbands[field]
+	return band, ok
+}
+
+// Changed reports whether a message with these fields should be published on
+// topic: nothing was sent there yet, the heartbeat has elapsed, or a field
+// changed.
+func (f *ChangeFilter) Changed(topic, subTopic string, fields []jsonField, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	last, ok := f.sent[topic]
+	if !ok || len(last.fields) != len(fields) {
+		return true
+	}
+	if f.heartbeat > 0 && now.Sub(last.at) >= f.heartbeat {
+		return true
+	}
+	for _, field := range fields {
+		previous, ok := last.fields[field.Key]
+		if !ok {
+			return true
+		}
+		if f.fieldChanged(subTopic, field.Key, previous, field.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldChanged compares one field with its last published value.
+func (f *ChangeFilter) fieldChanged(subTopic, field string, previous, current json.RawMessage) bool {
+	band, ok := f.deadbandFor(subTopic, field)
+	if ok {
+		var lastNumber, currentNumber float64
+		if json.Unmarshal(previous, &lastNumber) == nil && json.Unmarshal(current, &currentNumber) == nil {
+			return band.exceeded(lastNumber, currentNumber)
+		}
+	}
+	return !bytes.Equal(previous, current)
+}
+
+// Sent records the fields published on topic.
+func (f *ChangeFilter) Sent(topic string, fields []jsonField, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	values := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		values[field.Key] = field.Value
+	}
+	f.sent[topic] = sentMessage{at: now, fields: values}
+}
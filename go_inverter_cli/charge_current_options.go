@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ChargeCurrentOptions holds the charge current values a device accepts for
+// MNCHGC (QMCHGCR) and MUCHGC (QMUCHGCR).
+type ChargeCurrentOptions struct {
+	MaxChargingCurrents        []int
+	MaxUtilityChargingCurrents []int
+}
+
+// ChargeCurrentOptionsCache caches the selectable charge currents per device path.
+type ChargeCurrentOptionsCache struct {
+	mu      sync.RWMutex
+	options map[string]*ChargeCurrentOptions
+}
+
+// NewChargeCurrentOptionsCache creates an empty cache.
+func NewChargeCurrentOptionsCache() *ChargeCurrentOptionsCache {
+	return &ChargeCurrentOptionsCache{
+		options: make(map[string]*ChargeCurrentOptions),
+	}
+}
+
+// Get returns the cached options for device, or nil if they have not been queried yet.
+func (c *ChargeCurrentOptionsCache) Get(device string) *ChargeCurrentOptions {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.options[device]
+}
+
+// Refresh queries QMCHGCR and QMUCHGCR on the communicator's device and caches the result.
+func (c *ChargeCurrentOptionsCache) Refresh(communicator *InverterCommunicator, parser *InverterParser) (*ChargeCurrentOptions, error) {
+	rawResponse, err := sendCommandWithTimeout(communicator, "QMCHGCR", 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("error sending QMCHGCR command: %w", err)
+	}
+	maxChargingCurrents, err := parser.ParseQMCHGCRResponse(rawResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing QMCHGCR response: %w", err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	rawResponse, err = sendCommandWithTimeout(communicator, "QMUCHGCR", 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("error sending QMUCHGCR command: %w", err)
+	}
+	maxUtilityChargingCurrents, err := parser.ParseQMUCHGCRResponse(rawResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing QMUCHGCR response: %w", err)
+	}
+
+	options := &ChargeCurrentOptions{
+		MaxChargingCurrents:        maxChargingCurrents,
+		MaxUtilityChargingCurrents: maxUtilityChargingCurrents,
+	}
+
+	c.mu.Lock()
+	c.options[communicator.devicePath] = options
+	c.mu.Unlock()
+	return options, nil
+}
+
+// ValidateMaxChargingCurrent checks amps against the QMCHGCR values cached for device.
+func (c *ChargeCurrentOptionsCache) ValidateMaxChargingCurrent(device string, amps int) error {
+	options := c.Get(device)
+	if options == nil {
+		return fmt.Errorf("selectable max charging currents for %s are unknown, query QMCHGCR first", device)
+	}
+	return validateSelectableCurrent("max charging current", amps, options.MaxChargingCurrents)
+}
+
+// ValidateMaxUtilityChargingCurrent checks amps against the QMUCHGCR values cached for device.
+func (c *ChargeCurrentOptionsCache) ValidateMaxUtilityChargingCurrent(device string, amps int) error {
+	options := c.Get(device)
+	if options == nil {
+		return fmt.Errorf("selectable max utility charging currents for %s are unknown, query QMUCHGCR first", device)
+	}
+	return validateSelectableCurrent("max utility charging current", amps, options.MaxUtilityChargingCurrents)
+}
+
+func validateSelectableCurrent(name string, amps int, allowed []int) error {
+	for _, value := range allowed {
+		if value == amps {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s %dA is not selectable on this device, allowed values: %v", name, amps, allowed)
+}
+
+// SelectOptions formats selectable currents as the string options of a
+// Home Assistant select entity.
+func SelectOptions(values []int) []string {
+	options := make([]string, 0, len(values))
+	for _, value := range values {
+		options = append(options, strconv.Itoa(value))
+	}
+	return options
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	_ "time/tzdata" // The alpine runtime image ships without zoneinfo
+)
+
+// ClockStatusData describes the inverter clock relative to the host clock.
+type ClockStatusData struct {
+	InverterTime string
+	HostTime     string
+	Timezone     string
+	DriftSeconds float64 // Positive when the inverter clock is ahead of the host
+	Synced       bool
+}
+
+// ClockSync measures inverter clock drift via QT and optionally corrects it via DAT.
+type ClockSync struct {
+	communicator   *InverterCommunicator
+	parser         *InverterParser
+	setter         *InverterSetter
+	location       *time.Location
+	autoSync       bool
+	driftThreshold time.Duration
+}
+
+// NewClockSync creates a clock monitor. The timezone is an IANA name such as
+// "Africa/Johannesburg", or "Local" for the host zone.
+func NewClockSync(communicator *InverterCommunicator, parser *InverterParser, setter *InverterSetter, timezone string, autoSync bool, driftThreshold time.Duration) (*ClockSync, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %w", timezone, err)
+	}
+	return &ClockSync{
+		communicator:   communicator,
+		parser:         parser,
+		setter:         setter,
+		location:       location,
+		autoSync:       autoSync,
+		driftThreshold: driftThreshold,
+	}, nil
+}
+
+// Location returns the timezone the inverter clock runs in.
+func (cs *ClockSync) Location() *time.Location {
+	return cs.location
+}
+
+// queryInverterTime reads the inverter clock with QT; its time of day is taken
+// to be in location.
+func queryInverterTime(communicator *InverterCommunicator, parser *InverterParser, location *time.Location) (*QTData, error) {
+	rawResponse, err := sendCommandNoCRCWithTimeout(communicator, "QT", 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("error sending QT command: %w", err)
+	}
+	qtData, err := parser.ParseQTResponse(rawResponse, location)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing QT response: %w", err)
+	}
+	return qtData, nil
+}
+
+// Check reads the inverter clock, measures its drift against the host and, when
+// auto-sync is enabled and the drift exceeds the threshold, sets it with DAT.
+func (cs *ClockSync) Check() (*ClockStatusData, error) {
+	qtData, err := queryInverterTime(cs.communicator, cs.parser, cs.location)
+	hostTime := time.Now().In(cs.location)
+	if err != nil {
+		return nil, err
+	}
+
+	drift := qtData.InverterTime.Sub(hostTime)
+	status := &ClockStatusData{
+		InverterTime: qtData.InverterTime.Format(time.RFC3339),
+		HostTime:     hostTime.Format(time.RFC3339),
+		Timezone:     cs.location.String(),
+		DriftSeconds: drift.Seconds(),
+	}
+
+	if !cs.autoSync || drift.Abs() <= cs.driftThreshold {
+		return status, nil
+	}
+
+	syncTime := time.Now().In(cs.location)
+	fmt.Printf("Clock: Inverter clock drift %v exceeds %v, syncing %s -> %s\n",
+		drift.Round(time.Second), cs.driftThreshold, status.InverterTime, syncTime.Format(time.RFC3339))
+	if err := cs.setter.SetDateTime(syncTime); err != nil {
+		fmt.Printf("Clock: Sync failed: %v\n", err)
+		return status, fmt.Errorf("error syncing inverter clock: %w", err)
+	}
+	fmt.Printf("Clock: Sync succeeded, inverter clock set to %s\n", syncTime.Format(time.RFC3339))
+	status.Synced = true
+	return status, nil
+}
+
+// SetDateTime sets the inverter clock with DAT<YYMMDDHHMMSS>.
+func (s *InverterSetter) SetDateTime(t time.Time) error {
+	return s.sendSetting("DAT"+t.Format("060102150405"), true)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DestructiveCommandsConfig holds the "destructive_commands" section of the
+// config file. Every command stays disabled unless it is listed in Allowed.
+type DestructiveCommandsConfig struct {
+	Allowed     []string `json:"allowed"`      // e.g. ["RTEY"]
+	SnapshotDir string   `json:"snapshot_dir"` // where the automatic settings snapshot is written
+}
+
+// IsAllowed reports whether command is listed in Allowed.
+func (c DestructiveCommandsConfig) IsAllowed(command string) bool {
+	for _, allowed := range c.Allowed {
+		if strings.EqualFold(strings.TrimSpace(allowed), command) {
+			return true
+		}
+	}
+	return false
+}
+
+// DestructiveCommand is an irreversible command without parameters.
+type DestructiveCommand struct {
+	Command     string
+	Description string
+}
+
+// destructiveCommands lists the guarded commands by subcommand argument.
+var destructiveCommands = map[string]DestructiveCommand{
+	"pf":   {Command: "PF", Description: "reset all control parameters to their default values (factory reset)"},
+	"rtey": {Command: "RTEY", Description: "erase all stored PV/load energy data"},
+	"rtdl": {Command: "RTDL", Description: "erase the data log"},
+	"bta0": {Command: "BTA0", Description: "reset the battery voltage calibration (BTA1/BTA2) to its default"},
+}
+
+// RunDestructiveCommand sends one of the destructiveCommands. It is only
+// available to CLI setters, so setters created for MQTT, HTTP or automation
+// rules cannot reach it whatever topic or rule names it.
+func (s *InverterSetter) RunDestructiveCommand(command DestructiveCommand) error {
+	if s.origin != AuditOriginCLI {
+		return fmt.Errorf("%s can only be run from the command line, not from %s", command.Command, s.origin)
+	}
+	return s.sendSetting(command.Command, true)
+}
+
+// writeSafetySnapshot exports the live settings into dir before a destructive
+// command and returns the file name.
+func writeSafetySnapshot(setter *InverterSetter, dir, command string) (string, error) {
+	live, err := setter.readLiveSettings()
+	if err != nil {
+		return "", fmt.Errorf("failed to read the settings for the safety snapshot: %w", err)
+	}
+	now := time.Now()
+	content, err := MarshalSettingsSnapshot(NewSettingsSnapshot(live, now), "json")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("settings-before-%s-%s.json", command, now.Format("20060102T150405")))
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return "", fmt.Errorf("failed to write the safety snapshot %s: %w", path, err)
+	}
+	return path, nil
+}
+
+// confirmDestructiveCommand asks the operator to type the command name.
+func confirmDestructiveCommand(in io.Reader, command DestructiveCommand) error {
+	fmt.Printf("\n%s will %s. This cannot be undone.\nType %s to continue: ", command.Command, command.Description, command.Command)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && answer == "" {
+		return fmt.Errorf("no confirmation received, %s was not sent", command.Command)
+	}
+	if strings.TrimSpace(answer) != command.Command {
+		return fmt.Errorf("confirmation did not match, %s was not sent", command.Command)
+	}
+	return nil
+}
+
+// runResetCommand implements the `reset` subcommand. A command is only sent
+// when it is allowed in the config, -i-understand is given, the settings
+// snapshot was written and the operator typed the command name.
+func runResetCommand(args []string) error {
+	fs, devicePtr := newSubcommandFlagSet("reset")
+	configPtr := fs.String("config", "/app/mqtt.json", "Config file whose destructive_commands section allows the command")
+	understandPtr := fs.Bool("i-understand", false, "Confirm that the command is irreversible")
+	fs.Usage = func() {
+		names := make([]string, 0, len(destructiveCommands))
+		for name := range destructiveCommands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(fs.Output(), "Usage: go_inverter_cli reset [flags] <%s>\n\n", strings.Join(names, "|"))
+		for _, name := range names {
+			fmt.Fprintf(fs.Output(), "  %-6s %s\n", name, destructiveCommands[name].Description)
+		}
+		fmt.Fprintf(fs.Output(), "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one command")
+	}
+	command, ok := destructiveCommands[strings.ToLower(fs.Arg(0))]
+	if !ok {
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", fs.Arg(0))
+	}
+
+	config, err := LoadMQTTConfig(*configPtr)
+	if err != nil {
+		return err
+	}
+	if !config.DestructiveCommands.IsAllowed(command.Command) {
+		return fmt.Errorf("%s is disabled, add it to destructive_commands.allowed in %s to enable it", command.Command, *configPtr)
+	}
+	if !*understandPtr {
+		return fmt.Errorf("%s is irreversible, pass -i-understand to run it", command.Command)
+	}
+
+	communicator, err := openSubcommandDevice(*devicePtr)
+	if err != nil {
+		return err
+	}
+	defer communicator.CloseDevice()
+	setter := NewInverterSetter(communicator, NewInverterParser())
+
+	if setter.dryRun {
+		return setter.RunDestructiveCommand(command)
+	}
+
+	snapshotDir := config.DestructiveCommands.SnapshotDir
+	if snapshotDir == "" {
+		snapshotDir = "."
+	}
+	snapshotPath, err := writeSafetySnapshot(setter, snapshotDir, command.Command)
+	if err != nil {
+		return fmt.Errorf("%w, %s was not sent", err, command.Command)
+	}
+	fmt.Printf("Settings snapshot written to %s\n", snapshotPath)
+
+	if err := confirmDestructiveCommand(os.Stdin, command); err != nil {
+		return err
+	}
+	time.Sleep(300 * time.Millisecond)
+	if err := setter.RunDestructiveCommand(command); err != nil {
+		return err
+	}
+	fmt.Printf("\n%s acknowledged.\n", command.Command)
+	if command.Command == "PF" {
+		fmt.Printf("Restore the previous settings with: settings apply %s\n", snapshotPath)
+	}
+	return nil
+}
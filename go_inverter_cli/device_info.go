@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// DeviceInfo identifies the inverter; it also forms the discovery device block.
+type DeviceInfo struct {
+	SerialNumber    string // QID
+	ModelName       string // QMN
+	FirmwareVersion string // QVFW
+}
+
+// queryDeviceInfo reads QID, QMN and QVFW. A query the model NAKs only leaves
+// its attribute out of the device block.
+func queryDeviceInfo(communicator *InverterCommunicator, parser *InverterParser) DeviceInfo {
+	var info DeviceInfo
+	queries := []struct {
+		command string
+		parse   func(string) (string, error)
+		value   *string
+	}{
+		{"QID", parser.ParseQIDResponse, &info.SerialNumber},
+		{"QMN", parser.ParseQMNResponse, &info.ModelName},
+		{"QVFW", parser.ParseQVFWResponse, &info.FirmwareVersion},
+	}
+	for i, query := range queries {
+		if i > 0 {
+			time.Sleep(300 * time.Millisecond)
+		}
+		rawResponse, err := sendCommandWithTimeout(communicator, query.command, 2*time.Second)
+		if err == nil {
+			*query.value, err = query.parse(rawResponse)
+		}
+		if err != nil {
+			fmt.Printf("Error querying %s: %v\n", query.command, err)
+		}
+	}
+	return info
+}
+
+// pollDeviceInfo queries the device info and publishes it under device_info.
+// It returns nil when none of the queries was answered, so it is retried.
+func pollDeviceInfo(communicator *InverterCommunicator, parser *InverterParser, publisher *MQTTPublisher) *DeviceInfo {
+	fmt.Println("\nSending QID/QMN/QVFW commands...")
+	info := queryDeviceInfo(communicator, parser)
+	if info == (DeviceInfo{}) {
+		return nil
+	}
+
+	fmt.Printf("Device info: %+v\n", info)
+	err := publisher.PublishData(info, "device_info")
+	if err != nil {
+		fmt.Printf("Error publishing device info to MQTT: %v\n", err)
+	}
+	return &info
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// DiagnosticsData holds link-level state that explains odd polling behaviour
+// rather than describing the power system itself.
+type DiagnosticsData struct {
+	// WiFiLinkActive is set when the Wi-Fi dongle talks to the inverter over
+	// RS232 (QWFS), i.e. the vendor dongle may be competing for the port.
+	WiFiLinkActive bool
+	// BMSCommunicationMode is set when the inverter accepts BMS messages (QBMS).
+	BMSCommunicationMode bool
+}
+
+// queryDiagnostics reads QWFS and QBMS. A failed query is reported as an error
+// instead of a false flag so a dropped reply is not mistaken for "inactive".
+func queryDiagnostics(communicator *InverterCommunicator, parser *InverterParser) (*DiagnosticsData, error) {
+	data := &DiagnosticsData{}
+
+	rawResponse, err := sendCommandWithTimeout(communicator, "QWFS", 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("error sending QWFS command: %w", err)
+	}
+	data.WiFiLinkActive, err = parser.ParseQWFSResponse(rawResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing QWFS response: %w", err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	rawResponse, err = sendCommandWithTimeout(communicator, "QBMS", 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("error sending QBMS command: %w", err)
+	}
+	data.BMSCommunicationMode, err = parser.ParseQBMSResponse(rawResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing QBMS response: %w", err)
+	}
+
+	return data, nil
+}
+
+// pollDiagnostics queries QWFS/QBMS and publishes them under diagnostics.
+func pollDiagnostics(communicator *InverterCommunicator, parser *InverterParser, publisher *MQTTPublisher) {
+	fmt.Println("\nSending QWFS/QBMS commands...")
+	diagnosticsData, err := queryDiagnostics(communicator, parser)
+	if err != nil {
+		fmt.Printf("Error querying diagnostics: %v\n", err)
+		return
+	}
+	fmt.Printf("Diagnostics Data: %+v\n", diagnosticsData)
+	if diagnosticsData.WiFiLinkActive {
+		fmt.Println("Warning: the Wi-Fi module RS232 link is active and may be competing for the inverter port")
+	}
+	err = publisher.PublishData(diagnosticsData, "diagnostics")
+	if err != nil {
+		fmt.Printf("Error publishing diagnostics data to MQTT: %v\n", err)
+	}
+}
+
+// runDiagnosticsCommand implements the `diagnostics` subcommand.
+func runDiagnosticsCommand(args []string) error {
+	fs, devicePtr := newSubcommandFlagSet("diagnostics")
+	fs.Parse(args)
+
+	communicator, err := openSubcommandDevice(*devicePtr)
+	if err != nil {
+		return err
+	}
+	defer communicator.CloseDevice()
+
+	diagnosticsData, err := queryDiagnostics(communicator, NewInverterParser())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nWi-Fi module RS232 link active: %t\n", diagnosticsData.WiFiLinkActive)
+	fmt.Printf("BMS communication mode:          %t\n", diagnosticsData.BMSCommunicationMode)
+	return nil
+}
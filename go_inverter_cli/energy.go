@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Energy query builders. The inverter keeps PV generated (QE*) and output
+// load (QL*) energy in total and per year, month and day.
+
+// QETCommand builds the total PV generated energy query.
+func QETCommand() string { return "QET" }
+
+// QEYCommand builds the PV generated energy query for the year of date, e.g. QEY2026.
+func QEYCommand(date time.Time) string { return "QEY" + date.Format("2006") }
+
+// QEMCommand builds the PV generated energy query for the month of date, e.g. QEM202610.
+func QEMCommand(date time.Time) string { return "QEM" + date.Format("200601") }
+
+// QEDCommand builds the PV generated energy query for the day of date, e.g. QED20261017.
+func QEDCommand(date time.Time) string { return "QED" + date.Format("20060102") }
+
+// QLTCommand builds the total output load energy query.
+func QLTCommand() string { return "QLT" }
+
+// QLYCommand builds the output load energy query for the year of date, e.g. QLY2026.
+func QLYCommand(date time.Time) string { return "QLY" + date.Format("2006") }
+
+// QLMCommand builds the output load energy query for the month of date, e.g. QLM202610.
+func QLMCommand(date time.Time) string { return "QLM" + date.Format("200601") }
+
+// QLDCommand builds the output load energy query for the day of date, e.g. QLD20261017.
+func QLDCommand(date time.Time) string { return "QLD" + date.Format("20060102") }
+
+// EnergyData holds the inverter-side energy counters for one day, in Wh.
+type EnergyData struct {
+	Date              string
+	PVGeneratedDay    int
+	PVGeneratedMonth  int
+	PVGeneratedYear   int
+	PVGeneratedTotal  int
+	LoadConsumedDay   int
+	LoadConsumedMonth int
+	LoadConsumedYear  int
+	LoadConsumedTotal int
+}
+
+// queryEnergy sends one energy query and returns its value in Wh.
+func queryEnergy(communicator *InverterCommunicator, parser *InverterParser, command string) (int, error) {
+	rawResponse, err := sendCommandWithTimeout(communicator, command, 2*time.Second)
+	if err != nil {
+		return 0, fmt.Errorf("error sending %s command: %w", command, err)
+	}
+	return parser.ParseEnergyResponse(rawResponse, command)
+}
+
+// queryEnergyCounters reads the day, month, year and total counters for date.
+func queryEnergyCounters(communicator *InverterCommunicator, parser *InverterParser, date time.Time) (*EnergyData, error) {
+	data := &EnergyData{Date: date.Format("2006-01-02")}
+	queries := []struct {
+		command string
+		target  *int
+	}{
+		{QEDCommand(date), &data.PVGeneratedDay},
+		{QEMCommand(date), &data.PVGeneratedMonth},
+		{QEYCommand(date), &data.PVGeneratedYear},
+		{QETCommand(), &data.PVGeneratedTotal},
+		{QLDCommand(date), &data.LoadConsumedDay},
+		{QLMCommand(date), &data.LoadConsumedMonth},
+		{QLYCommand(date), &data.LoadConsumedYear},
+		{QLTCommand(), &data.LoadConsumedTotal},
+	}
+
+	for i, query := range queries {
+		if i > 0 {
+			time.Sleep(300 * time.Millisecond)
+		}
+		value, err := queryEnergy(communicator, parser, query.command)
+		if err != nil {
+			return nil, err
+		}
+		*query.target = value
+	}
+	return data, nil
+}
+
+// pollEnergy reads today's energy counters (in the inverter's timezone) and
+// publishes them under energy.
+func pollEnergy(communicator *InverterCommunicator, parser *InverterParser, publisher *MQTTPublisher, location *time.Location) {
+	fmt.Println("\nSending energy (QE*/QL*) commands...")
+	energyData, err := queryEnergyCounters(communicator, parser, time.Now().In(location))
+	if err != nil {
+		fmt.Printf("Error querying energy counters: %v\n", err)
+		return
+	}
+	fmt.Printf("Parsed energy Data: %+v\n", energyData)
+	err = publisher.PublishData(energyData, "energy")
+	if err != nil {
+		fmt.Printf("Error publishing energy data to MQTT: %v\n", err)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Equalization setting limits from the protocol (PBEQT, PBEQP, PBEQOT).
+const (
+	equalizationMinMinutes  = 5
+	equalizationMaxMinutes  = 900
+	equalizationMinuteStep  = 5
+	equalizationMaxPeriod   = 90
+	equalizationVoltageSpan = 4.0 / 3.0 // e.g. 48.00-64.00V on a 48V bank
+)
+
+// queryEqualizationStatus reads the equalization status via QBEQI.
+func queryEqualizationStatus(communicator *InverterCommunicator, parser *InverterParser) (*QBEQIData, error) {
+	rawResponse, err := sendCommandWithTimeout(communicator, "QBEQI", 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("error sending QBEQI command: %w", err)
+	}
+	qbeqiData, err := parser.ParseQBEQIResponse(rawResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing QBEQI response: %w", err)
+	}
+	return qbeqiData, nil
+}
+
+// SetEqualizationEnabled enables or disables battery equalization (PBEQE).
+func (s *InverterSetter) SetEqualizationEnabled(enabled bool) error {
+	return s.applySetting("PBEQE"+boolDigit(enabled),
+		s.verifyQBEQI("equalization enabled", func(q *QBEQIData) float64 { return flagValue(q.Enabled) }, flagValue(enabled)))
+}
+
+// SetEqualizationTime sets the equalization time in minutes (PBEQT, 5-900 in steps of 5).
+func (s *InverterSetter) SetEqualizationTime(minutes int) error {
+	if err := validateIntRange("equalization time", minutes, equalizationMinMinutes, equalizationMaxMinutes, equalizationMinuteStep); err != nil {
+		return err
+	}
+	return s.applySetting(fmt.Sprintf("PBEQT%03d", minutes),
+		s.verifyQBEQI("equalization time", func(q *QBEQIData) float64 { return float64(q.TimeMinutes) }, float64(minutes)))
+}
+
+// SetEqualizationPeriod sets the equalization period in days (PBEQP, 0-90).
+func (s *InverterSetter) SetEqualizationPeriod(days int) error {
+	if err := validateIntRange("equalization period", days, 0, equalizationMaxPeriod, 1); err != nil {
+		return err
+	}
+	return s.applySetting(fmt.Sprintf("PBEQP%03d", days),
+		s.verifyQBEQI("equalization period", func(q *QBEQIData) float64 { return float64(q.PeriodDays) }, float64(days)))
+}
+
+// SetEqualizationVoltage sets the equalization voltage (PBEQV). The accepted
+// range scales with the battery rating voltage reported by QPIRI.
+func (s *InverterSetter) SetEqualizationVoltage(volts float64) error {
+	qpiriData, err := s.queryQPIRI()
+	if err != nil {
+		return err
+	}
+	minVolts := qpiriData.BatteryRatingVoltage
+	maxVolts := qpiriData.BatteryRatingVoltage * equalizationVoltageSpan
+	if err := validateFloatRange("equalization voltage", volts, minVolts, maxVolts); err != nil {
+		return err
+	}
+	return s.applySetting(fmt.Sprintf("PBEQV%05.2f", volts),
+		s.verifyQBEQI("equalization voltage", func(q *QBEQIData) float64 { return q.Voltage }, volts))
+}
+
+// SetEqualizationOverTime sets the equalization over time in minutes (PBEQOT, 5-900 in steps of 5).
+func (s *InverterSetter) SetEqualizationOverTime(minutes int) error {
+	if err := validateIntRange("equalization over time", minutes, equalizationMinMinutes, equalizationMaxMinutes, equalizationMinuteStep); err != nil {
+		return err
+	}
+	return s.applySetting(fmt.Sprintf("PBEQOT%03d", minutes),
+		s.verifyQBEQI("equalization over time", func(q *QBEQIData) float64 { return float64(q.OverTimeMinutes) }, float64(minutes)))
+}
+
+// SetEqualizationActive starts or stops an equalization cycle immediately (PBEQA).
+func (s *InverterSetter) SetEqualizationActive(active bool) error {
+	return s.applySetting("PBEQA"+boolDigit(active),
+		s.verifyQBEQI("equalization active", func(q *QBEQIData) float64 { return flagValue(q.Active) }, flagValue(active)))
+}
+
+// pollEqualization queries QBEQI and publishes it under equalization.
+func pollEqualization(communicator *InverterCommunicator, parser *InverterParser, publisher *MQTTPublisher) {
+	fmt.Println("\nSending QBEQI command...")
+	qbeqiData, err := queryEqualizationStatus(communicator, parser)
+	if err != nil {
+		fmt.Printf("Error querying equalization status: %v\n", err)
+		return
+	}
+	fmt.Printf("Parsed QBEQI Data: %+v\n", qbeqiData)
+	err = publisher.PublishData(qbeqiData, "equalization")
+	if err != nil {
+		fmt.Printf("Error publishing QBEQI data to MQTT: %v\n", err)
+	}
+}
+
+// runEqualizationCommand implements the `equalization` subcommand. Without
+// flags it prints the QBEQI status; each flag sends the matching PBEQ* command.
+func runEqualizationCommand(args []string) error {
+	fs, devicePtr := newSubcommandFlagSet("equalization")
+	var enabled, active optionalBool
+	fs.Var(&enabled, "enabled", "Enable or disable equalization (on/off)")
+	timePtr := fs.Int("time", -1, "Equalization time in minutes (5-900, step 5)")
+	periodPtr := fs.Int("period", -1, "Equalization period in days (0-90)")
+	voltagePtr := fs.Float64("voltage", -1, "Equalization voltage")
+	overTimePtr := fs.Int("over-time", -1, "Equalization over time in minutes (5-900, step 5)")
+	fs.Var(&active, "active", "Start or stop equalization now (on/off)")
+	fs.Parse(args)
+
+	communicator, err := openSubcommandDevice(*devicePtr)
+	if err != nil {
+		return err
+	}
+	defer communicator.CloseDevice()
+
+	parser := NewInverterParser()
+	setter := NewInverterSetter(communicator, parser)
+
+	// Apply the parameters before enabling/activating so a cycle never starts with stale values
+	steps := []struct {
+		apply bool
+		run   func() error
+	}{
+		{*timePtr >= 0, func() error { return setter.SetEqualizationTime(*timePtr) }},
+		{*periodPtr >= 0, func() error { return setter.SetEqualizationPeriod(*periodPtr) }},
+		{*voltagePtr >= 0, func() error { return setter.SetEqualizationVoltage(*voltagePtr) }},
+		{*overTimePtr >= 0, func() error { return setter.SetEqualizationOverTime(*overTimePtr) }},
+		{enabled.set, func() error { return setter.SetEqualizationEnabled(enabled.value) }},
+		{active.set, func() error { return setter.SetEqualizationActive(active.value) }},
+	}
+	for _, step := range steps {
+		if !step.apply {
+			continue
+		}
+		if err := step.run(); err != nil {
+			return err
+		}
+		time.Sleep(300 * time.Millisecond)
+	}
+
+	qbeqiData, err := queryEqualizationStatus(communicator, parser)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nEqualization status: %+v\n", qbeqiData)
+	return nil
+}
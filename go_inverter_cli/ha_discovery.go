@@ -0,0 +1,541 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DiscoveryConfig holds the "discovery" section of the config file.
+type DiscoveryConfig struct {
+	Enabled string `json:"enabled"`
+	Prefix  string `json:"prefix"` // Home Assistant discovery prefix, "homeassistant" if empty
+}
+
+// IsEnabled reports whether discovery is switched on ("enabled": "true").
+func (c DiscoveryConfig) IsEnabled() bool {
+	return strings.EqualFold(strings.TrimSpace(c.Enabled), "true")
+}
+
+// DiscoveryPrefix returns the configured prefix or the Home Assistant default.
+func (c DiscoveryConfig) DiscoveryPrefix() string {
+	if prefix := strings.Trim(strings.TrimSpace(c.Prefix), "/"); prefix != "" {
+		return prefix
+	}
+	return "homeassistant"
+}
+
+// discoveryDevice is the device block that groups all entities in Home Assistant.
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model,omitempty"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+	SerialNumber string   `json:"serial_number,omitempty"`
+}
+
+// discoveryEntity is one retained config message.
+type discoveryEntity struct {
+	Component string `json:"-"` // sensor, binary_sensor, select, number or switch
+	Key       string `json:"-"` // last topic level before /config
+	Remove    bool   `json:"-"` // publish an empty config so Home Assistant drops the entity
+
+	Name              string                  `json:"name"`
+	UniqueID          string                  `json:"unique_id"`
+	ObjectID          string                  `json:"object_id"`
+	StateTopic        string                  `json:"state_topic,omitempty"`
+	ValueTemplate     string                  `json:"value_template,omitempty"`
+	CommandTopic      string                  `json:"command_topic,omitempty"`
+	CommandTemplate   string                  `json:"command_template,omitempty"`
+	DeviceClass       string                  `json:"device_class,omitempty"`
+	StateClass        string                  `json:"state_class,omitempty"`
+	UnitOfMeasurement string                  `json:"unit_of_measurement,omitempty"`
+	Options           []string                `json:"options,omitempty"`
+	Min               *float64                `json:"min,omitempty"`
+	Max               *float64                `json:"max,omitempty"`
+	Step              *float64                `json:"step,omitempty"`
+	Mode              string                  `json:"mode,omitempty"`
+	PayloadOn         string                  `json:"payload_on,omitempty"`
+	PayloadOff        string                  `json:"payload_off,omitempty"`
+	Availability      []discoveryAvailability `json:"availability"`
+	AvailabilityMode  string                  `json:"availability_mode"`
+	Device            discoveryDevice         `json:"device"`
+}
+
+// discoveryAvailability is one availability topic of an entity.
+type discoveryAvailability struct {
+	Topic string `json:"topic"`
+}
+
+// discoveryUnit describes the measurement of a field matched by name.
+type discoveryUnit struct {
+	match       string
+	unit        string
+	deviceClass string
+	stateClass  string
+}
+
+// discoveryUnits is checked in order against the field names; the first
+// match wins, so the more specific names come first.
+var discoveryUnits = []discoveryUnit{
+	{"Temp", "°C", "temperature", "measurement"},
+	{"Frequency", "Hz", "frequency", "measurement"},
+	{"ApparentPower", "VA", "apparent_power", "measurement"},
+	{"Power", "W", "power", "measurement"},
+	{"Voltage", "V", "voltage", "measurement"},
+	{"Current", "A", "current", "measurement"},
+	{"Capacity", "%", "battery", "measurement"},
+	{"SOC", "%", "battery", "measurement"},
+	{"Percent", "%", "", "measurement"},
+	{"PVGenerated", "Wh", "energy", "total_increasing"},
+	{"LoadConsumed", "Wh", "energy", "total_increasing"},
+	{"TimeAtCV", "min", "duration", ""},
+	{"Minutes", "min", "duration", ""},
+	{"Hours", "h", "duration", ""},
+	{"Days", "d", "duration", ""},
+	{"Seconds", "s", "duration", "measurement"},
+}
+
+// discoverySource is a struct published under subTopic whose fields become sensors.
+type discoverySource struct {
+	subTopic string
+	name     string // entity name prefix, empty for the main status
+	data     interface{}
+}
+
+// Value names used by the select entities, keyed like QPIRI reports them.
+var (
+	gridWorkingRangeNames = map[int]string{0: "Appliance", 1: "UPS"}
+	batteryTypeNames      = map[int]string{
+		0: "AGM", 1: "Flooded", 2: "User", 3: "Pylontech", 4: "Shinheung",
+		5: "Weco", 6: "Soltaro", 7: "BAK", 8: "Lib", 9: "Lic",
+	}
+)
+
+// HADiscovery publishes Home Assistant MQTT discovery configs for the
+// published fields, the settings allowed in remote_settings and the PBATCD
+// switches, grouped under one device built from QID/QMN/QVFW.
+type HADiscovery struct {
+	config     MQTTConfig
+	publisher  *MQTTPublisher
+	device     *discoveryDevice
+	identifier string
+	configs    map[string]string // payload by config topic, as last published
+	connection uint32            // publisher connection they were published on
+}
+
+// NewHADiscovery prepares discovery; nothing is published until Publish.
+func NewHADiscovery(config MQTTConfig, publisher *MQTTPublisher) *HADiscovery {
+	return &HADiscovery{config: config, publisher: publisher}
+}
+
+// setDevice builds the device block; the serial number identifies it, or the
+// device name when the model does not answer QID.
+func (d *HADiscovery) setDevice(info DeviceInfo) {
+	d.identifier = info.SerialNumber
+	if d.identifier == "" {
+		d.identifier = discoveryID(d.config.DeviceName)
+	}
+	d.device = &discoveryDevice{
+		Identifiers:  []string{d.identifier},
+		Name:         d.config.DeviceName,
+		Manufacturer: "Voltronic Power",
+		Model:        info.ModelName,
+		SWVersion:    info.FirmwareVersion,
+		SerialNumber: info.SerialNumber,
+	}
+}
+
+// newEntity fills in the fields every entity shares. key must be unique per
+// component of the device, e.g. "battery_voltage" or "rating_battery_type".
+// Entities are only available while both the poller and the inverter are.
+func (d *HADiscovery) newEntity(component, key, name string) discoveryEntity {
+	return discoveryEntity{
+		Component: component,
+		Key:       key,
+		Name:      name,
+		UniqueID:  d.identifier + "_" + key,
+		ObjectID:  discoveryID(d.config.DeviceName) + "_" + key,
+		Availability: []discoveryAvailability{
+			{Topic: d.publisher.Topic(availabilitySubTopic)},
+			{Topic: d.publisher.Topic(inverterAvailabilitySubTopic)},
+		},
+		AvailabilityMode: "all",
+		Device:           *d.device,
+	}
+}
+
+// flat reports whether entities read the per-field topics; with the "both"
+// layout they keep reading the JSON topics.
+func (d *HADiscovery) flat() bool {
+	layout, _ := d.config.Layout()
+	return layout == TopicLayoutFlat
+}
+
+// stateTopic returns the topic a field of a sub-topic is read from.
+func (d *HADiscovery) stateTopic(subTopic, field string) string {
+	if d.flat() {
+		return d.config.FlatTopic(subTopic, field)
+	}
+	return d.publisher.Topic(subTopic)
+}
+
+// value returns the template expression of a field, under its namingMap name.
+func (d *HADiscovery) value(subTopic, field string) string {
+	if d.flat() {
+		return "value"
+	}
+	return fmt.Sprintf("value_json['%s']", d.config.FieldName(subTopic, field))
+}
+
+// boolValue is value for a bool field, which a flat topic carries as text.
+func (d *HADiscovery) boolValue(subTopic, field string) string {
+	if d.flat() {
+		return "value == 'true'"
+	}
+	return d.value(subTopic, field)
+}
+
+// sensorEntities turns every scalar field of source.data into a sensor, or a
+// binary_sensor for bools. Units and classes are inferred from the field name.
+func (d *HADiscovery) sensorEntities(source discoverySource) []discoveryEntity {
+	var entities []discoveryEntity
+	dataType := reflect.TypeOf(source.data)
+	for i := 0; i < dataType.NumField(); i++ {
+		field := dataType.Field(i)
+		jsonName := jsonFieldName(field)
+		if jsonName == "" {
+			continue
+		}
+		key := snakeCase(field.Name)
+		name := strings.Join(splitFieldName(field.Name), " ")
+		if source.name != "" {
+			key = discoveryID(source.name) + "_" + key
+			name = source.name + " " + name
+		}
+
+		var entity discoveryEntity
+		switch field.Type.Kind() {
+		case reflect.Bool:
+			entity = d.newEntity("binary_sensor", key, name)
+			entity.ValueTemplate = fmt.Sprintf("{{ 'ON' if %s else 'OFF' }}", d.boolValue(source.subTopic, jsonName))
+		case reflect.Int, reflect.Int64, reflect.Float64:
+			entity = d.newEntity("sensor", key, name)
+			entity.ValueTemplate = fmt.Sprintf("{{ %s }}", d.value(source.subTopic, jsonName))
+			for _, unit := range discoveryUnits {
+				if strings.Contains(field.Name, unit.match) {
+					entity.UnitOfMeasurement = unit.unit
+					entity.DeviceClass = unit.deviceClass
+					entity.StateClass = unit.stateClass
+					break
+				}
+			}
+		case reflect.String:
+			entity = d.newEntity("sensor", key, name)
+			entity.ValueTemplate = fmt.Sprintf("{{ %s }}", d.value(source.subTopic, jsonName))
+		default:
+			continue // arrays and nested structs, e.g. the LED colours
+		}
+		entity.StateTopic = d.stateTopic(source.subTopic, jsonName)
+		entities = append(entities, entity)
+	}
+	return entities
+}
+
+// selectEntity offers the named values of a setting; Home Assistant shows the
+// names and the command template sends the number the setter expects.
+func (d *HADiscovery) selectEntity(setting, subTopic, field string, names map[int]string) discoveryEntity {
+	values := make([]int, 0, len(names))
+	for value := range names {
+		values = append(values, value)
+	}
+	sort.Ints(values)
+
+	entity := d.newEntity("select", setting, settingDisplayName(setting))
+	var toName, toValue []string
+	for _, value := range values {
+		entity.Options = append(entity.Options, names[value])
+		toName = append(toName, fmt.Sprintf("%d: '%s'", value, names[value]))
+		toValue = append(toValue, fmt.Sprintf("'%s': %d", names[value], value))
+	}
+	entity.StateTopic = d.stateTopic(subTopic, field)
+	entity.ValueTemplate = fmt.Sprintf("{{ {%s}[%s | int] }}", strings.Join(toName, ", "), d.value(subTopic, field))
+	entity.CommandTopic = d.publisher.Topic("set/" + setting)
+	entity.CommandTemplate = fmt.Sprintf("{{ {%s}[value] }}", strings.Join(toValue, ", "))
+	return entity
+}
+
+// numberEntity accepts a value between min and max in steps of step.
+func (d *HADiscovery) numberEntity(setting, subTopic, field, unit string, min, max, step float64) discoveryEntity {
+	entity := d.newEntity("number", setting, settingDisplayName(setting))
+	entity.StateTopic = d.stateTopic(subTopic, field)
+	entity.ValueTemplate = fmt.Sprintf("{{ %s }}", d.value(subTopic, field))
+	entity.CommandTopic = d.publisher.Topic("set/" + setting)
+	entity.UnitOfMeasurement = unit
+	entity.Min, entity.Max, entity.Step = &min, &max, &step
+	entity.Mode = "box"
+	return entity
+}
+
+// switchEntity sends on/off to commandTopic and reads a bool field back.
+func (d *HADiscovery) switchEntity(key, name, commandTopic, subTopic, field string) discoveryEntity {
+	entity := d.newEntity("switch", key, name)
+	entity.StateTopic = d.stateTopic(subTopic, field)
+	entity.ValueTemplate = fmt.Sprintf("{{ 'on' if %s else 'off' }}", d.boolValue(subTopic, field))
+	entity.CommandTopic = d.publisher.Topic(commandTopic)
+	entity.PayloadOn = "on"
+	entity.PayloadOff = "off"
+	return entity
+}
+
+// settingEntities returns a select, number or switch for each setting with a
+// published value. Settings missing from remote_settings.allowed are removed,
+// so taking one off the list also takes it out of Home Assistant. The
+// charge current selects need the QMCHGCR/QMUCHGCR options and are left out
+// until those are known.
+func (d *HADiscovery) settingEntities(qpiriData *QPIRIData, options *ChargeCurrentOptions) []discoveryEntity {
+	var entities []discoveryEntity
+	add := func(entity discoveryEntity) {
+		entity.Remove = !d.config.RemoteSettings.IsAllowed(entity.Key)
+		entities = append(entities, entity)
+	}
+
+	add(d.selectEntity("output_source_priority", "rating", "OutputSourcePriority", outputPriorityNames))
+	add(d.selectEntity("charger_source_priority", "rating", "ChargerSourcePriority", chargerPriorityNames))
+	add(d.selectEntity("grid_working_range", "rating", "InputVoltageRange", gridWorkingRangeNames))
+	add(d.selectEntity("battery_type", "rating", "BatteryType", batteryTypeNames))
+	add(d.selectEntity("output_frequency", "rating", "ACOutputRatingFrequency", valueNames([]int{50, 60})))
+	outputVoltages := hvOutputVoltages
+	if isLVModel(qpiriData) {
+		outputVoltages = lvOutputVoltages
+	}
+	add(d.selectEntity("output_voltage", "rating", "ACOutputRatingVoltage", valueNames(outputVoltages)))
+	if options != nil {
+		add(d.selectEntity("max_charging_current", "rating", "MaxChargingCurrent", valueNames(options.MaxChargingCurrents)))
+		add(d.selectEntity("max_utility_charging_current", "rating", "MaxACChargingCurrent", valueNames(options.MaxUtilityChargingCurrents)))
+	}
+
+	scale := qpiriData.BatteryRatingVoltage / referenceBatteryVoltage
+	for _, voltage := range []struct {
+		setting string
+		field   string
+		limits  BatteryVoltageSetting
+	}{
+		{"battery_cutoff_voltage", "BatteryUnderVoltage", CutoffVoltage},
+		{"battery_cv_voltage", "BatteryBulkVoltage", CVVoltage},
+		{"battery_float_voltage", "BatteryFloatVoltage", FloatVoltage},
+		{"battery_recharge_voltage", "BatteryRechargeVoltage", RechargeVoltage},
+		{"battery_redischarge_voltage", "BatteryRedischargeVoltage", RedischargeVoltage},
+	} {
+		limits := batteryVoltageRanges[voltage.limits]
+		min := limits.min * scale
+		if voltage.limits == RedischargeVoltage {
+			min = 0 // battery full
+		}
+		add(d.numberEntity(voltage.setting, "rating", voltage.field, "V", min, limits.max*scale, 0.1))
+	}
+	add(d.numberEntity("max_charging_time_at_cv", "rating", "MaxChargingTimeAtCVStage", "min", 0, maxChargingTimeAtCV, chargingTimeAtCVStep))
+	add(d.numberEntity("max_discharging_current", "rating", "MaxDischargingCurrent", "A", 0, maxMaxDischargingAmps, 1))
+
+	add(d.switchEntity("equalization_enabled", settingDisplayName("equalization_enabled"), "set/equalization_enabled", "equalization", "Enabled"))
+	add(d.switchEntity("equalization_active", settingDisplayName("equalization_active"), "set/equalization_active", "equalization", "Active"))
+	add(d.numberEntity("equalization_time", "equalization", "TimeMinutes", "min", equalizationMinMinutes, equalizationMaxMinutes, equalizationMinuteStep))
+	add(d.numberEntity("equalization_period", "equalization", "PeriodDays", "d", 0, equalizationMaxPeriod, 1))
+	add(d.numberEntity("equalization_over_time", "equalization", "OverTimeMinutes", "min", equalizationMinMinutes, equalizationMaxMinutes, equalizationMinuteStep))
+	add(d.numberEntity("equalization_voltage", "equalization", "Voltage", "V",
+		qpiriData.BatteryRatingVoltage, qpiriData.BatteryRatingVoltage*equalizationVoltageSpan, 0.01))
+
+	for _, name := range batterySwitches {
+		key := batterySwitchSetting(name)
+		add(d.switchEntity(key, settingDisplayName(key), "battery_control/"+name+"/set", "battery_control", name))
+	}
+	return entities
+}
+
+// Entities returns every discovery config: the sensors of the published
+// structs and the writable settings, the PBATCD switches included.
+func (d *HADiscovery) Entities(qpiriData *QPIRIData, options *ChargeCurrentOptions, parallelUnits []*QPGSData) []discoveryEntity {
+	sources := []discoverySource{
+		{"state", "", QPIGSData{}},
+		{"rating", "Rating", QPIRIData{}},
+		{"pv2", "", QPIGS2Data{}},
+		{"warnings", "", QPIWSData{}},
+		{"equalization", "Equalization", QBEQIData{}},
+		{"led", "LED", QLEDData{}},
+		{"diagnostics", "", DiagnosticsData{}},
+		{"energy", "Energy", EnergyData{}},
+		{"clock", "Clock", ClockStatusData{}},
+	}
+	if d.config.BMSBridge.IsEnabled() {
+		sources = append(sources, discoverySource{"bms_bridge", "BMS Bridge", BMSBridgeStatus{}})
+	}
+	if len(parallelUnits) > 0 {
+		sources = append(sources, discoverySource{"parallel/system", "Parallel", ParallelSystemData{}})
+	}
+	for _, unit := range parallelUnits {
+		sources = append(sources, discoverySource{fmt.Sprintf("parallel/%d", unit.UnitIndex), fmt.Sprintf("Unit %d", unit.UnitIndex), QPGSData{}})
+	}
+
+	var entities []discoveryEntity
+	for _, source := range sources {
+		entities = append(entities, d.sensorEntities(source)...)
+	}
+	entities = append(entities, d.settingEntities(qpiriData, options)...)
+	return entities
+}
+
+// ConfigTopic returns <prefix>/<component>/<devicename>/<key>/config.
+func (d *HADiscovery) ConfigTopic(entity discoveryEntity) string {
+	return fmt.Sprintf("%s/%s/%s/%s/config", d.config.Discovery.DiscoveryPrefix(), entity.Component, discoveryID(d.config.DeviceName), entity.Key)
+}
+
+// Publish sends the configs as retained messages, so Home Assistant picks
+// them up whenever it (re)starts. It is a no-op while the configs match the
+// ones last published on the current MQTT connection, so it can run every
+// poll: new parallel units, settings or ratings and broker reconnects all
+// republish. Configs of entities that disappeared since are cleared.
+func (d *HADiscovery) Publish(info DeviceInfo, qpiriData *QPIRIData, options *ChargeCurrentOptions, parallelUnits []*QPGSData) error {
+	d.setDevice(info)
+
+	var topics []string
+	configs := make(map[string]string)
+	published := 0
+	for _, entity := range d.Entities(qpiriData, options, parallelUnits) {
+		var payload []byte
+		if !entity.Remove {
+			var err error
+			payload, err = json.Marshal(entity)
+			if err != nil {
+				return fmt.Errorf("failed to marshal discovery config %s: %w", entity.Key, err)
+			}
+			published++
+		}
+		topic := d.ConfigTopic(entity)
+		topics = append(topics, topic)
+		configs[topic] = string(payload)
+	}
+	connection := d.publisher.Connections()
+	if d.configs != nil && connection == d.connection && sameConfigs(d.configs, configs) {
+		return nil
+	}
+
+	for _, topic := range topics {
+		if err := d.publisher.PublishRetained(topic, []byte(configs[topic])); err != nil {
+			return err
+		}
+	}
+	for topic := range d.configs {
+		if _, ok := configs[topic]; !ok {
+			if err := d.publisher.PublishRetained(topic, nil); err != nil {
+				return err
+			}
+		}
+	}
+	d.configs = configs
+	d.connection = connection
+	fmt.Printf("Published %d Home Assistant discovery configs under %s/.\n", published, d.config.Discovery.DiscoveryPrefix())
+	return nil
+}
+
+// sameConfigs reports whether two sets of configs have the same topics and payloads.
+func sameConfigs(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for topic, payload := range a {
+		if other, ok := b[topic]; !ok || other != payload {
+			return false
+		}
+	}
+	return true
+}
+
+// runDiscovery publishes the discovery configs once the ratings are known,
+// and again whenever they change.
+func runDiscovery(discovery *HADiscovery, info DeviceInfo, qpiriData *QPIRIData, options *ChargeCurrentOptions, parallelUnits []*QPGSData) {
+	if err := discovery.Publish(info, qpiriData, options, parallelUnits); err != nil {
+		fmt.Printf("Error publishing Home Assistant discovery: %v\n", err)
+	}
+}
+
+// valueNames names each value by itself, for selects of plain numbers.
+func valueNames(values []int) map[int]string {
+	names := make(map[int]string, len(values))
+	for _, value := range values {
+		names[value] = strconv.Itoa(value)
+	}
+	return names
+}
+
+// jsonFieldName returns the key a field is marshalled under, or "" if it is skipped.
+func jsonFieldName(field reflect.StructField) string {
+	if field.PkgPath != "" {
+		return ""
+	}
+	tag := strings.Split(field.Tag.Get("json"), ",")[0]
+	if tag == "-" {
+		return ""
+	}
+	if tag != "" {
+		return tag
+	}
+	return field.Name
+}
+
+// splitFieldName splits a Go field name into words, keeping acronyms and
+// their digits together: "PV1InputCurrent" gives PV1, Input, Current.
+func splitFieldName(name string) []string {
+	runes := []rune(name)
+	var words []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		if !unicode.IsUpper(runes[i]) {
+			continue
+		}
+		previous := runes[i-1]
+		acronymEnd := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+		if unicode.IsLower(previous) || acronymEnd {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	return append(words, string(runes[start:]))
+}
+
+// snakeCase turns a Go field name into an entity key: "ACOutputVoltage" gives "ac_output_voltage".
+func snakeCase(name string) string {
+	return strings.ToLower(strings.Join(splitFieldName(name), "_"))
+}
+
+// settingDisplayName turns a setting name into an entity name: "max_charging_time_at_cv"
+// gives "Max Charging Time At CV".
+func settingDisplayName(setting string) string {
+	words := strings.Split(setting, "_")
+	for i, word := range words {
+		switch word {
+		case "cv":
+			words[i] = "CV"
+		default:
+			words[i] = strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// discoveryID reduces a name to the characters allowed in discovery topics and object IDs.
+func discoveryID(name string) string {
+	var id strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '-' {
+			id.WriteRune(r)
+		} else {
+			id.WriteRune('_')
+		}
+	}
+	return id.String()
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// InfluxConfig holds the InfluxDB (v1 HTTP API) time-series sink settings
+// from the "influx" section of the config file.
+type InfluxConfig struct {
+	Enabled  string `json:"enabled"`
+	Host     string `json:"host"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Device   string `json:"device"` // "device" tag; defaults to the serial number (QID)
+	Prefix   string `json:"prefix"`
+	Database string `json:"database"`
+}
+
+// IsEnabled reports whether the sink is switched on ("enabled": "true").
+func (c InfluxConfig) IsEnabled() bool {
+	return strings.EqualFold(c.Enabled, "true")
+}
+
+// WithDevice returns the config with Device defaulted to the serial number
+// from device_info when none is configured.
+func (c InfluxConfig) WithDevice(info DeviceInfo) InfluxConfig {
+	if c.Device == "" {
+		c.Device = info.SerialNumber
+	}
+	return c
+}
+
+// InfluxPoint is a single line-protocol point.
+type InfluxPoint struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]int
+	Time        time.Time
+}
+
+// InfluxWriter writes points to InfluxDB using the line protocol.
+type InfluxWriter struct {
+	config InfluxConfig
+	client *http.Client
+}
+
+// NewInfluxWriter creates a writer for the configured sink.
+func NewInfluxWriter(config InfluxConfig) *InfluxWriter {
+	return &InfluxWriter{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Measurement prefixes name with the configured prefix, e.g. solar_energy.
+func (w *InfluxWriter) Measurement(name string) string {
+	if w.config.Prefix == "" {
+		return name
+	}
+	return w.config.Prefix + "_" + name
+}
+
+// Tags returns the tags every point carries. The device tag is left out when
+// it is still unknown, as the line protocol rejects empty tag values.
+func (w *InfluxWriter) Tags() map[string]string {
+	tags := make(map[string]string)
+	if w.config.Device != "" {
+		tags["device"] = w.config.Device
+	}
+	return tags
+}
+
+// Write sends points in one request with second precision.
+func (w *InfluxWriter) Write(points []InfluxPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	for _, point := range points {
+		body.WriteString(point.line())
+		body.WriteByte('\n')
+	}
+
+	query := url.Values{}
+	query.Set("db", w.config.Database)
+	query.Set("precision", "s")
+	writeURL := strings.TrimSuffix(w.config.Host, "/") + "/write?" + query.Encode()
+
+	req, err := http.NewRequest(http.MethodPost, writeURL, &body)
+	if err != nil {
+		return fmt.Errorf("failed to build InfluxDB request: %w", err)
+	}
+	if w.config.Username != "" {
+		req.SetBasicAuth(w.config.Username, w.config.Password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to write to InfluxDB: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("InfluxDB write failed with %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+var influxEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+func (p InfluxPoint) line() string {
+	var line strings.Builder
+	line.WriteString(influxEscaper.Replace(p.Measurement))
+	for _, key := range sortedKeys(p.Tags) {
+		fmt.Fprintf(&line, ",%s=%s", influxEscaper.Replace(key), influxEscaper.Replace(p.Tags[key]))
+	}
+	fieldKeys := make([]string, 0, len(p.Fields))
+	for key := range p.Fields {
+		fieldKeys = append(fieldKeys, key)
+	}
+	sort.Strings(fieldKeys)
+	for i, key := range fieldKeys {
+		separator := ","
+		if i == 0 {
+			separator = " "
+		}
+		fmt.Fprintf(&line, "%s%s=%di", separator, influxEscaper.Replace(key), p.Fields[key])
+	}
+	fmt.Fprintf(&line, " %d", p.Time.Unix())
+	return line.String()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
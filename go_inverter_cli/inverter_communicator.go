@@ -58,8 +58,28 @@ func calculateCRC(data []byte) []byte {
 	return []byte{byte((crc >> 8) & 0xFF), byte(crc & 0xFF)}
 }
 
+// BuildCommandFrame returns the bytes written for a command: the command,
+// its CRC (unless the command has none) and a carriage return (CR).
+func BuildCommandFrame(command string, useCRC bool) []byte {
+	cmdBytes := []byte(command)
+	if useCRC {
+		cmdBytes = append(cmdBytes, calculateCRC(cmdBytes)...)
+	}
+	return append(cmdBytes, '\r')
+}
+
 // SendCommand sends a command to the inverter and reads its response.
 func (ic *InverterCommunicator) SendCommand(command string) (string, error) {
+	return ic.sendCommand(command, true)
+}
+
+// SendCommandNoCRC sends a command without a CRC and reads a response that has
+// none, as used by QT, QLED and the PLED* commands.
+func (ic *InverterCommunicator) SendCommandNoCRC(command string) (string, error) {
+	return ic.sendCommand(command, false)
+}
+
+func (ic *InverterCommunicator) sendCommand(command string, useCRC bool) (string, error) {
 	if ic.deviceFile == nil {
 		return "", fmt.Errorf("device not open")
 	}
@@ -98,11 +118,7 @@ func (ic *InverterCommunicator) SendCommand(command string) (string, error) {
 		// Data was read, continue flushing
 	}
 
-	// Commands need to be terminated with CRC and a carriage return (CR)
-	cmdWithCRC := []byte(command)
-	crc := calculateCRC(cmdWithCRC)
-	cmdBytes := append(cmdWithCRC, crc...)
-	cmdBytes = append(cmdBytes, '\r')
+	cmdBytes := BuildCommandFrame(command, useCRC)
 
 	// Write the command
 	fmt.Printf("Communicator: Sending command '%s' (bytes: %x)\n", command, cmdBytes)
@@ -141,6 +157,14 @@ func (ic *InverterCommunicator) SendCommand(command string) (string, error) {
 			if crIndex != -1 { // If CR is found
 				fullResponseWithCR := response[:crIndex+1]
 
+				if !useCRC {
+					dataPart := fullResponseWithCR[:len(fullResponseWithCR)-1]
+					for len(dataPart) > 0 && (dataPart[len(dataPart)-1] == 0 || dataPart[len(dataPart)-1] < 32) {
+						dataPart = dataPart[:len(dataPart)-1]
+					}
+					return string(dataPart), nil
+				}
+
 				if len(fullResponseWithCR) < 3 {
 					return "", fmt.Errorf("response too short to contain data, CRC, and CR")
 			}
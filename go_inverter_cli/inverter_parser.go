@@ -1,11 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// ErrNAK is returned when the inverter rejects a setting command with (NAK.
+var ErrNAK = errors.New("inverter responded NAK")
+
 // InverterParser handles parsing raw inverter responses into structured data.
 type InverterParser struct {
 	// Add any necessary fields here, e.g., for caching or specific parsing rules
@@ -39,6 +44,12 @@ type QPIGSData struct {
 	EEPROMVersion               int
 	PV1ChargingPower            int
 	DeviceStatus2               string // Raw bit string for now
+	LoadStatusOn                bool   // DeviceStatus1 b4
+	SCCChargeOn                 bool   // DeviceStatus1 b1
+	ACChargeOn                  bool   // DeviceStatus1 b0
+	ChargingToFloatingMode      bool   // DeviceStatus2 b10
+	SwitchOn                    bool   // DeviceStatus2 b9
+	DustproofInstalled          bool   // DeviceStatus2 b8
 	// Add more fields as needed based on data_format.md
 }
 
@@ -165,6 +176,11 @@ func (ip *InverterParser) ParseQPIGSResponse(rawResponse string) (*QPIGSData, er
 
 	// Field 17: Device Status 1 (b7..b0)
 	data.DeviceStatus1 = parts[16]
+	if len(data.DeviceStatus1) == 8 {
+		data.LoadStatusOn = data.DeviceStatus1[3] == '1'
+		data.SCCChargeOn = data.DeviceStatus1[6] == '1'
+		data.ACChargeOn = data.DeviceStatus1[7] == '1'
+	}
 
 	// Field 18: Battery V offset for fans on (QQ)
 	data.BatteryVOffsetForFansOn, err = strconv.Atoi(parts[17])
@@ -180,6 +196,11 @@ func (ip *InverterParser) ParseQPIGSResponse(rawResponse string) (*QPIGSData, er
 
 	// Field 21: Device Status 2 (b10b9b8)
 	data.DeviceStatus2 = parts[20]
+	if len(data.DeviceStatus2) == 3 {
+		data.ChargingToFloatingMode = data.DeviceStatus2[0] == '1'
+		data.SwitchOn = data.DeviceStatus2[1] == '1'
+		data.DustproofInstalled = data.DeviceStatus2[2] == '1'
+	}
 
 	return data, nil
 }
@@ -392,3 +413,496 @@ func (ip *InverterParser) ParseQDIResponse(rawResponse string) (*QDIData, error)
 	return data, nil
 }
 
+
+// QPGSData holds the parsed data from a QPGSn (parallel information) command.
+type QPGSData struct {
+	UnitIndex                  int
+	UnitExists                 bool
+	SerialNumber               string
+	WorkMode                   string
+	FaultCode                  int
+	GridVoltage                float64
+	GridFrequency              float64
+	ACOutputVoltage            float64
+	ACOutputFrequency          float64
+	ACOutputApparentPower      int
+	ACOutputActivePower        int
+	LoadPercent                int
+	BatteryVoltage             float64
+	BatteryChargingCurrent     int
+	BatteryCapacity            int
+	PV1InputVoltage            float64
+	TotalChargingCurrent       int
+	TotalACOutputApparentPower int
+	TotalACOutputActivePower   int
+	TotalACOutputPercent       int
+	InverterStatus             string // Raw bit string b7..b0
+	SCCOK                      bool
+	ACCharging                 bool
+	SCCCharging                bool
+	BatteryStatus              int // 0: normal, 1: under, 2: open
+	LineLoss                   bool
+	LoadOn                     bool
+	ConfigurationChanged       bool
+	OutputMode                 int
+	ChargerSourcePriority      int
+	MaxChargerCurrent          int
+	MaxChargerRange            int
+	MaxACChargerCurrent        int
+	PV1InputCurrent            int
+	BatteryDischargeCurrent    int
+	PV2InputVoltage            float64 // MAXII only
+	PV2InputCurrent            int     // MAXII only
+}
+
+// ParseQPGSResponse parses the raw string response from the QPGSn command for the given unit index.
+func (ip *InverterParser) ParseQPGSResponse(rawResponse string, unitIndex int) (*QPGSData, error) {
+	cleanedResponse := strings.TrimPrefix(rawResponse, "(")
+	cleanedResponse = strings.TrimSuffix(cleanedResponse, "\r")
+	parts := strings.Fields(cleanedResponse)
+
+	if len(parts) > 0 && parts[0] == "NAK" {
+		return nil, fmt.Errorf("QPGS%d rejected by inverter (NAK)", unitIndex)
+	}
+	if len(parts) < 27 { // PV2 fields are only present on MAXII
+		return nil, fmt.Errorf("QPGS%d response has too few fields: %d", unitIndex, len(parts))
+	}
+
+	data := &QPGSData{UnitIndex: unitIndex}
+	var err error
+
+	data.UnitExists = parts[0] == "1"
+	data.SerialNumber = parts[1]
+	data.WorkMode = parts[2]
+	data.FaultCode, err = strconv.Atoi(parts[3])
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS FaultCode: %w", err) }
+	data.GridVoltage, err = strconv.ParseFloat(parts[4], 64)
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS GridVoltage: %w", err) }
+	data.GridFrequency, err = strconv.ParseFloat(parts[5], 64)
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS GridFrequency: %w", err) }
+	data.ACOutputVoltage, err = strconv.ParseFloat(parts[6], 64)
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS ACOutputVoltage: %w", err) }
+	data.ACOutputFrequency, err = strconv.ParseFloat(parts[7], 64)
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS ACOutputFrequency: %w", err) }
+	data.ACOutputApparentPower, err = strconv.Atoi(parts[8])
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS ACOutputApparentPower: %w", err) }
+	data.ACOutputActivePower, err = strconv.Atoi(parts[9])
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS ACOutputActivePower: %w", err) }
+	data.LoadPercent, err = strconv.Atoi(parts[10])
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS LoadPercent: %w", err) }
+	data.BatteryVoltage, err = strconv.ParseFloat(parts[11], 64)
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS BatteryVoltage: %w", err) }
+	data.BatteryChargingCurrent, err = strconv.Atoi(parts[12])
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS BatteryChargingCurrent: %w", err) }
+	data.BatteryCapacity, err = strconv.Atoi(parts[13])
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS BatteryCapacity: %w", err) }
+	data.PV1InputVoltage, err = strconv.ParseFloat(parts[14], 64)
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS PV1InputVoltage: %w", err) }
+	data.TotalChargingCurrent, err = strconv.Atoi(parts[15])
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS TotalChargingCurrent: %w", err) }
+	data.TotalACOutputApparentPower, err = strconv.Atoi(parts[16])
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS TotalACOutputApparentPower: %w", err) }
+	data.TotalACOutputActivePower, err = strconv.Atoi(parts[17])
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS TotalACOutputActivePower: %w", err) }
+	data.TotalACOutputPercent, err = strconv.Atoi(parts[18])
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS TotalACOutputPercent: %w", err) }
+
+	// Inverter status b7..b0
+	data.InverterStatus = parts[19]
+	if len(data.InverterStatus) != 8 {
+		return nil, fmt.Errorf("error parsing QPGS InverterStatus: expected 8 bits, got %q", data.InverterStatus)
+	}
+	data.SCCOK = data.InverterStatus[0] == '1'
+	data.ACCharging = data.InverterStatus[1] == '1'
+	data.SCCCharging = data.InverterStatus[2] == '1'
+	batteryStatus, err := strconv.ParseInt(data.InverterStatus[3:5], 2, 0)
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS BatteryStatus: %w", err) }
+	data.BatteryStatus = int(batteryStatus)
+	data.LineLoss = data.InverterStatus[5] == '1'
+	data.LoadOn = data.InverterStatus[6] == '1'
+	data.ConfigurationChanged = data.InverterStatus[7] == '1'
+
+	data.OutputMode, err = strconv.Atoi(parts[20])
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS OutputMode: %w", err) }
+	data.ChargerSourcePriority, err = strconv.Atoi(parts[21])
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS ChargerSourcePriority: %w", err) }
+	data.MaxChargerCurrent, err = strconv.Atoi(parts[22])
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS MaxChargerCurrent: %w", err) }
+	data.MaxChargerRange, err = strconv.Atoi(parts[23])
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS MaxChargerRange: %w", err) }
+	data.MaxACChargerCurrent, err = strconv.Atoi(parts[24])
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS MaxACChargerCurrent: %w", err) }
+	data.PV1InputCurrent, err = strconv.Atoi(parts[25])
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS PV1InputCurrent: %w", err) }
+	data.BatteryDischargeCurrent, err = strconv.Atoi(parts[26])
+	if err != nil { return nil, fmt.Errorf("error parsing QPGS BatteryDischargeCurrent: %w", err) }
+
+	if len(parts) >= 29 {
+		data.PV2InputVoltage, err = strconv.ParseFloat(parts[27], 64)
+		if err != nil { return nil, fmt.Errorf("error parsing QPGS PV2InputVoltage: %w", err) }
+		data.PV2InputCurrent, err = strconv.Atoi(parts[28])
+		if err != nil { return nil, fmt.Errorf("error parsing QPGS PV2InputCurrent: %w", err) }
+	}
+
+	return data, nil
+}
+
+// parseSelectableValues parses a variable-length, space separated list of
+// integers such as the QMCHGCR and QMUCHGCR responses.
+func parseSelectableValues(rawResponse string, command string) ([]int, error) {
+	cleanedResponse := strings.TrimPrefix(rawResponse, "(")
+	cleanedResponse = strings.TrimSuffix(cleanedResponse, "\r")
+	parts := strings.Fields(cleanedResponse)
+
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("%s response is empty", command)
+	}
+	if parts[0] == "NAK" {
+		return nil, fmt.Errorf("%s rejected by inverter (NAK)", command)
+	}
+
+	values := make([]int, 0, len(parts))
+	for _, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil { return nil, fmt.Errorf("error parsing %s value %q: %w", command, part, err) }
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// ParseQMCHGCRResponse parses the selectable max charging currents from the QMCHGCR command.
+func (ip *InverterParser) ParseQMCHGCRResponse(rawResponse string) ([]int, error) {
+	return parseSelectableValues(rawResponse, "QMCHGCR")
+}
+
+// ParseQMUCHGCRResponse parses the selectable max utility charging currents from the QMUCHGCR command.
+func (ip *InverterParser) ParseQMUCHGCRResponse(rawResponse string) ([]int, error) {
+	return parseSelectableValues(rawResponse, "QMUCHGCR")
+}
+
+// PriorityScheduleData holds a 24-hour source priority table from QOPPT or QCHPT.
+type PriorityScheduleData struct {
+	Hourly         [24]int // Priority for each hour 0..23
+	DevicePriority int     // Currently configured device priority (N)
+	PriorityOrder  []int   // Selection of priority order (O O)
+}
+
+// parsePriorityScheduleResponse parses the shared QOPPT/QCHPT layout:
+// 24 hourly values followed by N and the O O order fields.
+func parsePriorityScheduleResponse(rawResponse string, command string) (*PriorityScheduleData, error) {
+	cleanedResponse := strings.TrimPrefix(rawResponse, "(")
+	cleanedResponse = strings.TrimSuffix(cleanedResponse, "\r")
+	parts := strings.Fields(cleanedResponse)
+
+	if len(parts) > 0 && parts[0] == "NAK" {
+		return nil, fmt.Errorf("%s rejected by inverter (NAK)", command)
+	}
+	if len(parts) < 25 {
+		return nil, fmt.Errorf("%s response has too few fields: %d", command, len(parts))
+	}
+
+	data := &PriorityScheduleData{}
+	var err error
+
+	for hour := 0; hour < 24; hour++ {
+		data.Hourly[hour], err = strconv.Atoi(parts[hour])
+		if err != nil { return nil, fmt.Errorf("error parsing %s hour %d: %w", command, hour, err) }
+	}
+	data.DevicePriority, err = strconv.Atoi(parts[24])
+	if err != nil { return nil, fmt.Errorf("error parsing %s DevicePriority: %w", command, err) }
+	for _, part := range parts[25:] {
+		order, err := strconv.Atoi(part)
+		if err != nil { return nil, fmt.Errorf("error parsing %s PriorityOrder: %w", command, err) }
+		data.PriorityOrder = append(data.PriorityOrder, order)
+	}
+
+	return data, nil
+}
+
+// ParseQOPPTResponse parses the output source priority time order from the QOPPT command.
+func (ip *InverterParser) ParseQOPPTResponse(rawResponse string) (*PriorityScheduleData, error) {
+	return parsePriorityScheduleResponse(rawResponse, "QOPPT")
+}
+
+// ParseQCHPTResponse parses the charger source priority time order from the QCHPT command.
+func (ip *InverterParser) ParseQCHPTResponse(rawResponse string) (*PriorityScheduleData, error) {
+	return parsePriorityScheduleResponse(rawResponse, "QCHPT")
+}
+
+// ParseACKResponse checks the (ACK / (NAK reply of a setting command.
+func (ip *InverterParser) ParseACKResponse(rawResponse string, command string) error {
+	cleanedResponse := strings.TrimPrefix(rawResponse, "(")
+	cleanedResponse = strings.TrimSpace(cleanedResponse)
+
+	switch cleanedResponse {
+	case "ACK":
+		return nil
+	case "NAK":
+		return fmt.Errorf("%s: %w", command, ErrNAK)
+	default:
+		return fmt.Errorf("%s: unexpected response %q", command, rawResponse)
+	}
+}
+
+// QTData holds the parsed data from the QT command.
+type QTData struct {
+	InverterTime time.Time
+}
+
+// ParseQTResponse parses the raw string response from the QT command.
+// The inverter clock carries no zone, so it is interpreted in loc.
+func (ip *InverterParser) ParseQTResponse(rawResponse string, loc *time.Location) (*QTData, error) {
+	cleanedResponse := strings.TrimPrefix(rawResponse, "(")
+	cleanedResponse = strings.TrimSpace(cleanedResponse)
+
+	if cleanedResponse == "NAK" {
+		return nil, fmt.Errorf("QT rejected by inverter (NAK)")
+	}
+	if len(cleanedResponse) != 14 {
+		return nil, fmt.Errorf("QT response has unexpected length %d: %q", len(cleanedResponse), cleanedResponse)
+	}
+
+	inverterTime, err := time.ParseInLocation("20060102150405", cleanedResponse, loc)
+	if err != nil { return nil, fmt.Errorf("error parsing QT InverterTime: %w", err) }
+
+	return &QTData{InverterTime: inverterTime}, nil
+}
+
+// QBEQIData holds the parsed data from the QBEQI command.
+type QBEQIData struct {
+	Enabled         bool
+	TimeMinutes     int
+	PeriodDays      int
+	MaxCurrent      int
+	Voltage         float64
+	OverTimeMinutes int
+	Active          bool
+	ElapsedHours    int
+}
+
+// ParseQBEQIResponse parses the raw string response from the QBEQI command.
+func (ip *InverterParser) ParseQBEQIResponse(rawResponse string) (*QBEQIData, error) {
+	cleanedResponse := strings.TrimPrefix(rawResponse, "(")
+	cleanedResponse = strings.TrimSuffix(cleanedResponse, "\r")
+	parts := strings.Fields(cleanedResponse)
+
+	if len(parts) > 0 && parts[0] == "NAK" {
+		return nil, fmt.Errorf("QBEQI rejected by inverter (NAK)")
+	}
+	if len(parts) < 10 {
+		return nil, fmt.Errorf("QBEQI response has too few fields: %d", len(parts))
+	}
+
+	data := &QBEQIData{}
+	var err error
+
+	data.Enabled = parts[0] == "1"
+	data.TimeMinutes, err = strconv.Atoi(parts[1])
+	if err != nil { return nil, fmt.Errorf("error parsing QBEQI TimeMinutes: %w", err) }
+	data.PeriodDays, err = strconv.Atoi(parts[2])
+	if err != nil { return nil, fmt.Errorf("error parsing QBEQI PeriodDays: %w", err) }
+	data.MaxCurrent, err = strconv.Atoi(parts[3])
+	if err != nil { return nil, fmt.Errorf("error parsing QBEQI MaxCurrent: %w", err) }
+	// parts[4] is reserved
+	data.Voltage, err = strconv.ParseFloat(parts[5], 64)
+	if err != nil { return nil, fmt.Errorf("error parsing QBEQI Voltage: %w", err) }
+	// parts[6] is reserved
+	data.OverTimeMinutes, err = strconv.Atoi(parts[7])
+	if err != nil { return nil, fmt.Errorf("error parsing QBEQI OverTimeMinutes: %w", err) }
+	data.Active = parts[8] == "1"
+	data.ElapsedHours, err = strconv.Atoi(parts[9])
+	if err != nil { return nil, fmt.Errorf("error parsing QBEQI ElapsedHours: %w", err) }
+
+	return data, nil
+}
+
+// ParseEnergyResponse parses the (NNNNNNNN reply shared by the QET/QEY/QEM/QED
+// and QLT/QLY/QLM/QLD commands. The value is in Wh.
+func (ip *InverterParser) ParseEnergyResponse(rawResponse string, command string) (int, error) {
+	cleanedResponse := strings.TrimPrefix(rawResponse, "(")
+	cleanedResponse = strings.TrimSpace(cleanedResponse)
+
+	if cleanedResponse == "NAK" {
+		return 0, fmt.Errorf("%s rejected by inverter (NAK)", command)
+	}
+
+	energyWh, err := strconv.Atoi(cleanedResponse)
+	if err != nil { return 0, fmt.Errorf("error parsing %s energy: %w", command, err) }
+	return energyWh, nil
+}
+
+// LEDColor is an RGB colour of the LED ring, each channel 0-255.
+type LEDColor struct {
+	Red   int
+	Green int
+	Blue  int
+}
+
+// QLEDData holds the parsed data from the QLED command.
+type QLEDData struct {
+	Enabled      bool
+	Speed        int         // 0: low, 1: medium, 2: fast
+	Effect       int         // 0: power cycling, 1: power wheel, 2: power chasing, 3: solid on
+	Brightness   int         // 0: low, 1: medium, 2: high
+	Presentation int         // 0: PV power, 1: battery capacity, 2: load, 3: energy source, 4: battery charge/discharge
+	Colors       [3]LEDColor // Line mode, AVR mode, battery mode
+}
+
+// ParseQLEDResponse parses the raw string response from the QLED command.
+func (ip *InverterParser) ParseQLEDResponse(rawResponse string) (*QLEDData, error) {
+	cleanedResponse := strings.TrimPrefix(rawResponse, "(")
+	cleanedResponse = strings.TrimSuffix(cleanedResponse, "\r")
+	parts := strings.Fields(cleanedResponse)
+
+	if len(parts) > 0 && parts[0] == "NAK" {
+		return nil, fmt.Errorf("QLED rejected by inverter (NAK)")
+	}
+	if len(parts) < 8 {
+		return nil, fmt.Errorf("QLED response has too few fields: %d", len(parts))
+	}
+
+	data := &QLEDData{}
+	var err error
+
+	data.Enabled = parts[0] == "1"
+	data.Speed, err = strconv.Atoi(parts[1])
+	if err != nil { return nil, fmt.Errorf("error parsing QLED Speed: %w", err) }
+	data.Effect, err = strconv.Atoi(parts[2])
+	if err != nil { return nil, fmt.Errorf("error parsing QLED Effect: %w", err) }
+	data.Brightness, err = strconv.Atoi(parts[3])
+	if err != nil { return nil, fmt.Errorf("error parsing QLED Brightness: %w", err) }
+	data.Presentation, err = strconv.Atoi(parts[4])
+	if err != nil { return nil, fmt.Errorf("error parsing QLED Presentation: %w", err) }
+
+	// Colour triplets aaabbbccc
+	for i := 0; i < 3; i++ {
+		triplet := parts[5+i]
+		if len(triplet) != 9 {
+			return nil, fmt.Errorf("error parsing QLED colour %d: expected 9 digits, got %q", i+1, triplet)
+		}
+		data.Colors[i].Red, err = strconv.Atoi(triplet[0:3])
+		if err != nil { return nil, fmt.Errorf("error parsing QLED colour %d red: %w", i+1, err) }
+		data.Colors[i].Green, err = strconv.Atoi(triplet[3:6])
+		if err != nil { return nil, fmt.Errorf("error parsing QLED colour %d green: %w", i+1, err) }
+		data.Colors[i].Blue, err = strconv.Atoi(triplet[6:9])
+		if err != nil { return nil, fmt.Errorf("error parsing QLED colour %d blue: %w", i+1, err) }
+	}
+
+	return data, nil
+}
+
+// ParseQWFSResponse parses the raw string response from the QWFS command and
+// reports whether the Wi-Fi module RS232 link is active.
+func (ip *InverterParser) ParseQWFSResponse(rawResponse string) (bool, error) {
+	cleanedResponse := strings.TrimPrefix(rawResponse, "(")
+	cleanedResponse = strings.TrimSpace(cleanedResponse)
+
+	switch cleanedResponse {
+	case "1":
+		return true, nil
+	case "0":
+		return false, nil
+	case "NAK":
+		return false, fmt.Errorf("QWFS rejected by inverter (NAK)")
+	default:
+		return false, fmt.Errorf("QWFS: unexpected response %q", rawResponse)
+	}
+}
+
+// ParseQBMSResponse parses the raw string response from the QBMS command and
+// reports whether the inverter is in BMS communication mode (ACK) or not (NAK).
+func (ip *InverterParser) ParseQBMSResponse(rawResponse string) (bool, error) {
+	err := ip.ParseACKResponse(rawResponse, "QBMS")
+	if errors.Is(err, ErrNAK) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// QFLAGData holds the parsed data from the QFLAG command, keyed by flag letter
+// (a: buzzer, b: overload bypass, k: LCD escape, u: overload restart, ...).
+type QFLAGData struct {
+	Flags map[string]bool
+}
+
+// ParseQFLAGResponse parses the raw string response from the QFLAG command,
+// e.g. "(EakxyzDbuv": letters after E are enabled, letters after D disabled.
+func (ip *InverterParser) ParseQFLAGResponse(rawResponse string) (*QFLAGData, error) {
+	cleanedResponse := strings.TrimPrefix(rawResponse, "(")
+	cleanedResponse = strings.TrimSpace(cleanedResponse)
+
+	if cleanedResponse == "NAK" {
+		return nil, fmt.Errorf("QFLAG rejected by inverter (NAK)")
+	}
+	if !strings.HasPrefix(cleanedResponse, "E") && !strings.HasPrefix(cleanedResponse, "D") {
+		return nil, fmt.Errorf("QFLAG: unexpected response %q", rawResponse)
+	}
+
+	data := &QFLAGData{Flags: make(map[string]bool)}
+	enabled := false
+	for _, c := range cleanedResponse {
+		switch {
+		case c == 'E':
+			enabled = true
+		case c == 'D':
+			enabled = false
+		case c >= 'a' && c <= 'z':
+			data.Flags[string(c)] = enabled
+		default:
+			return nil, fmt.Errorf("QFLAG: unexpected character %q in %q", c, rawResponse)
+		}
+	}
+
+	return data, nil
+}
+
+// ParseQIDResponse parses the raw string response from the QID command and
+// returns the device serial number, e.g. "(92932004102443".
+func (ip *InverterParser) ParseQIDResponse(rawResponse string) (string, error) {
+	cleanedResponse := strings.TrimPrefix(rawResponse, "(")
+	cleanedResponse = strings.TrimSpace(cleanedResponse)
+
+	if cleanedResponse == "NAK" {
+		return "", fmt.Errorf("QID rejected by inverter (NAK)")
+	}
+	if cleanedResponse == "" || strings.ContainsAny(cleanedResponse, " \t") {
+		return "", fmt.Errorf("QID: unexpected response %q", rawResponse)
+	}
+
+	return cleanedResponse, nil
+}
+
+// ParseQVFWResponse parses the raw string response from the QVFW command and
+// returns the main CPU firmware version, e.g. "(VERFW:00072.70" gives "00072.70".
+func (ip *InverterParser) ParseQVFWResponse(rawResponse string) (string, error) {
+	cleanedResponse := strings.TrimPrefix(rawResponse, "(")
+	cleanedResponse = strings.TrimSpace(cleanedResponse)
+
+	if cleanedResponse == "NAK" {
+		return "", fmt.Errorf("QVFW rejected by inverter (NAK)")
+	}
+	if !strings.HasPrefix(cleanedResponse, "VERFW:") {
+		return "", fmt.Errorf("QVFW: unexpected response %q", rawResponse)
+	}
+
+	return strings.TrimPrefix(cleanedResponse, "VERFW:"), nil
+}
+
+// ParseQMNResponse parses the raw string response from the QMN command and
+// returns the model name with its rated VA, e.g. "(MKS2-8000".
+func (ip *InverterParser) ParseQMNResponse(rawResponse string) (string, error) {
+	cleanedResponse := strings.TrimPrefix(rawResponse, "(")
+	cleanedResponse = strings.TrimSpace(cleanedResponse)
+
+	if cleanedResponse == "NAK" {
+		return "", fmt.Errorf("QMN rejected by inverter (NAK)")
+	}
+	if cleanedResponse == "" {
+		return "", fmt.Errorf("QMN: unexpected response %q", rawResponse)
+	}
+
+	return cleanedResponse, nil
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// readBackTolerance absorbs the float formatting of nn.n values in QPIRI/QPGS.
+const readBackTolerance = 0.05
+
+// dryRunMode is set by the -dry-run flag; setters created while it is set
+// print what they would send instead of writing to the device.
+var dryRunMode bool
+
+// InverterSetter sends setting commands to the inverter and checks their ACK/NAK reply.
+type InverterSetter struct {
+	communicator         *InverterCommunicator
+	parser               *InverterParser
+	chargeCurrentOptions *ChargeCurrentOptionsCache
+	dryRun               bool
+
+	// Every change is recorded in the audit log under this origin and client ID
+	auditLog *AuditLog
+	origin   string
+	clientID string
+
+	// Set for repeated frames such as PBMS, which are still audited but not
+	// recorded again
+	skipRecord bool
+}
+
+// NewInverterSetter creates a new setter instance. Its changes are audited as
+// CLI changes by the current user until As says otherwise.
+func NewInverterSetter(communicator *InverterCommunicator, parser *InverterParser) *InverterSetter {
+	return &InverterSetter{
+		communicator:         communicator,
+		parser:               parser,
+		chargeCurrentOptions: NewChargeCurrentOptionsCache(),
+		dryRun:               dryRunMode,
+		auditLog:             NewAuditLog(auditLogPath),
+		origin:               AuditOriginCLI,
+		clientID:             currentUserName(),
+	}
+}
+
+// As returns a setter sharing this one's device whose changes are audited
+// under origin and clientID, e.g. As(AuditOriginAutomation, "clock-sync").
+func (s *InverterSetter) As(origin, clientID string) *InverterSetter {
+	scoped := *s
+	scoped.origin = origin
+	scoped.clientID = clientID
+	return &scoped
+}
+
+// sendSetting sends a setting command and returns an error unless the inverter
+// ACKs it. In dry-run mode it only prints the frame.
+func (s *InverterSetter) sendSetting(command string, useCRC bool) error {
+	return s.changeSetting(command, useCRC, nil)
+}
+
+// writeSetting writes a setting command and checks its ACK/NAK reply.
+func (s *InverterSetter) writeSetting(command string, useCRC bool) error {
+	fmt.Printf("Setter: Sending setting command '%s'\n", command)
+
+	var rawResponse string
+	var err error
+	if useCRC {
+		rawResponse, err = sendCommandWithTimeout(s.communicator, command, 2*time.Second)
+	} else {
+		rawResponse, err = sendCommandNoCRCWithTimeout(s.communicator, command, 2*time.Second)
+	}
+	if err != nil {
+		return fmt.Errorf("error sending %s command: %w", command, err)
+	}
+
+	return s.parser.ParseACKResponse(rawResponse, command)
+}
+
+// queryQPIRI reads the current ratings, which several setters validate against.
+func (s *InverterSetter) queryQPIRI() (*QPIRIData, error) {
+	rawResponse, err := sendCommandWithTimeout(s.communicator, "QPIRI", 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("error sending QPIRI command: %w", err)
+	}
+	qpiriData, err := s.parser.ParseQPIRIResponse(rawResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing QPIRI response: %w", err)
+	}
+	return qpiriData, nil
+}
+
+// queryQPIGS reads the live status, e.g. the battery voltage the inverter measures.
+func (s *InverterSetter) queryQPIGS() (*QPIGSData, error) {
+	rawResponse, err := sendCommandWithTimeout(s.communicator, "QPIGS", 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("error sending QPIGS command: %w", err)
+	}
+	qpigsData, err := s.parser.ParseQPIGSResponse(rawResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing QPIGS response: %w", err)
+	}
+	return qpigsData, nil
+}
+
+// queryQFLAG reads the enable/disable flags.
+func (s *InverterSetter) queryQFLAG() (*QFLAGData, error) {
+	rawResponse, err := sendCommandWithTimeout(s.communicator, "QFLAG", 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("error sending QFLAG command: %w", err)
+	}
+	qflagData, err := s.parser.ParseQFLAGResponse(rawResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing QFLAG response: %w", err)
+	}
+	return qflagData, nil
+}
+
+// queryQPGS reads the status of one unit of a parallel system.
+func (s *InverterSetter) queryQPGS(machine int) (*QPGSData, error) {
+	command := fmt.Sprintf("QPGS%d", machine)
+	rawResponse, err := sendCommandWithTimeout(s.communicator, command, 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("error sending %s command: %w", command, err)
+	}
+	qpgsData, err := s.parser.ParseQPGSResponse(rawResponse, machine)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s response: %w", command, err)
+	}
+	return qpgsData, nil
+}
+
+// settingReadBack describes where the inverter reports the value a setting
+// changes, so it can be verified after an ACK and diffed in dry-run mode.
+type settingReadBack struct {
+	source string // QPIRI, QPGS<m> or QFLAG
+	name   string
+	read   func() (float64, error)
+	want   float64
+}
+
+// compare checks a read-back value against the wanted one.
+func (r *settingReadBack) compare(got float64) error {
+	if math.Abs(got-r.want) > readBackTolerance {
+		return fmt.Errorf("%s reports %s %g instead of %g", r.source, r.name, got, r.want)
+	}
+	return nil
+}
+
+// applySetting sends a setting command and, once it is ACKed, checks readBack
+// to confirm that the inverter really took the new value.
+func (s *InverterSetter) applySetting(command string, readBack *settingReadBack) error {
+	return s.changeSetting(command, true, readBack)
+}
+
+// changeSetting is the single write path of the setter: it prints instead of
+// writing in dry-run mode, refuses to write without a working audit log, and
+// records the frame, the ACK/NAK result and the before/after read-back values.
+func (s *InverterSetter) changeSetting(command string, useCRC bool, readBack *settingReadBack) error {
+	if s.dryRun {
+		s.printDryRun(command, useCRC, readBack)
+		return nil
+	}
+	if err := s.auditLog.Check(); err != nil {
+		return fmt.Errorf("refusing to send %s without an audit record: %w", command, err)
+	}
+
+	entry := AuditEntry{
+		Timestamp: time.Now(),
+		Origin:    s.origin,
+		ClientID:  s.clientID,
+		Command:   command,
+		Frame:     fmt.Sprintf("%x", BuildCommandFrame(command, useCRC)),
+	}
+	if readBack != nil {
+		entry.Setting = readBack.source + " " + readBack.name
+		if before, err := readBack.read(); err == nil {
+			entry.Before = &before
+		}
+		time.Sleep(300 * time.Millisecond)
+	}
+
+	err := s.writeSetting(command, useCRC)
+	switch {
+	case err == nil:
+		entry.Result = "ACK"
+	case errors.Is(err, ErrNAK):
+		entry.Result = "NAK"
+	default:
+		entry.Result = "error"
+	}
+
+	if err == nil && readBack != nil {
+		time.Sleep(300 * time.Millisecond)
+		after, readErr := readBack.read()
+		if readErr != nil {
+			err = fmt.Errorf("%s was acknowledged but the read-back failed: %v", command, readErr)
+		} else {
+			entry.After = &after
+			if compareErr := readBack.compare(after); compareErr != nil {
+				err = fmt.Errorf("%s was acknowledged but %w", command, compareErr)
+			}
+		}
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if s.skipRecord {
+		return err
+	}
+	if recordErr := s.auditLog.Record(entry); recordErr != nil {
+		fmt.Printf("Audit: %v\n", recordErr)
+	}
+	return err
+}
+
+// printDryRun shows the frame that would be written and the expected change.
+func (s *InverterSetter) printDryRun(command string, useCRC bool, readBack *settingReadBack) {
+	fmt.Printf("Dry run: would send %s\n", command)
+	fmt.Printf("  frame: %x\n", BuildCommandFrame(command, useCRC))
+	if readBack == nil {
+		fmt.Println("  diff:  not available, the inverter does not report this setting")
+		return
+	}
+	before, err := readBack.read()
+	if err != nil {
+		fmt.Printf("  diff:  could not read the current %s value: %v\n", readBack.source, err)
+		return
+	}
+	if math.Abs(before-readBack.want) <= readBackTolerance {
+		fmt.Printf("  diff:  %s %s %g (unchanged)\n", readBack.source, readBack.name, before)
+		return
+	}
+	fmt.Printf("  diff:  %s %s %g -> %g\n", readBack.source, readBack.name, before, readBack.want)
+}
+
+// verifyQPIRI returns a read-back of a QPIRI field expected to become want.
+func (s *InverterSetter) verifyQPIRI(name string, field func(*QPIRIData) float64, want float64) *settingReadBack {
+	return &settingReadBack{
+		source: "QPIRI",
+		name:   name,
+		read: func() (float64, error) {
+			qpiriData, err := s.queryQPIRI()
+			if err != nil {
+				return 0, err
+			}
+			return field(qpiriData), nil
+		},
+		want: want,
+	}
+}
+
+// verifyQPGS returns a read-back of a QPGS<machine> field expected to become want.
+func (s *InverterSetter) verifyQPGS(machine int, name string, field func(*QPGSData) float64, want float64) *settingReadBack {
+	return &settingReadBack{
+		source: fmt.Sprintf("QPGS%d", machine),
+		name:   name,
+		read: func() (float64, error) {
+			qpgsData, err := s.queryQPGS(machine)
+			if err != nil {
+				return 0, err
+			}
+			if !qpgsData.UnitExists {
+				return 0, fmt.Errorf("parallel unit %d does not exist", machine)
+			}
+			return field(qpgsData), nil
+		},
+		want: want,
+	}
+}
+
+// verifyQFLAG returns a read-back of a QFLAG flag expected to become enabled (1) or disabled (0).
+func (s *InverterSetter) verifyQFLAG(flag, name string, enabled bool) *settingReadBack {
+	want := 0.0
+	if enabled {
+		want = 1
+	}
+	return &settingReadBack{
+		source: "QFLAG",
+		name:   name,
+		read: func() (float64, error) {
+			qflagData, err := s.queryQFLAG()
+			if err != nil {
+				return 0, err
+			}
+			value, ok := qflagData.Flags[flag]
+			if !ok {
+				return 0, fmt.Errorf("QFLAG does not report flag %s", flag)
+			}
+			if value {
+				return 1, nil
+			}
+			return 0, nil
+		},
+		want: want,
+	}
+}
+
+// verifyQBEQI returns a read-back of a QBEQI equalization field expected to become want.
+func (s *InverterSetter) verifyQBEQI(name string, field func(*QBEQIData) float64, want float64) *settingReadBack {
+	return &settingReadBack{
+		source: "QBEQI",
+		name:   name,
+		read: func() (float64, error) {
+			qbeqiData, err := queryEqualizationStatus(s.communicator, s.parser)
+			if err != nil {
+				return 0, err
+			}
+			return field(qbeqiData), nil
+		},
+		want: want,
+	}
+}
+
+// flagValue is the read-back value of an on/off setting.
+func flagValue(enabled bool) float64 {
+	if enabled {
+		return 1
+	}
+	return 0
+}
+
+// validateIntRange checks that value lies in [min, max] and is a multiple of step.
+func validateIntRange(name string, value, min, max, step int) error {
+	if value < min || value > max {
+		return fmt.Errorf("%s %d is out of range %d-%d", name, value, min, max)
+	}
+	if step > 1 && value%step != 0 {
+		return fmt.Errorf("%s %d must be a multiple of %d", name, value, step)
+	}
+	return nil
+}
+
+// validateFloatRange checks that value lies in [min, max].
+func validateFloatRange(name string, value, min, max float64) error {
+	if value < min || value > max {
+		return fmt.Errorf("%s %.2f is out of range %.2f-%.2f", name, value, min, max)
+	}
+	return nil
+}
+
+// boolDigit formats a flag as the 0/1 digit used by the enable/disable commands.
+func boolDigit(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LED colour slots for PLEDC, in the order QLED reports them.
+const (
+	LEDColorLineMode    = 1
+	LEDColorAVRMode     = 2
+	LEDColorBatteryMode = 3
+)
+
+// queryLEDStatus reads the LED ring status via QLED (no CRC).
+func queryLEDStatus(communicator *InverterCommunicator, parser *InverterParser) (*QLEDData, error) {
+	rawResponse, err := sendCommandNoCRCWithTimeout(communicator, "QLED", 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("error sending QLED command: %w", err)
+	}
+	qledData, err := parser.ParseQLEDResponse(rawResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing QLED response: %w", err)
+	}
+	return qledData, nil
+}
+
+// verifyQLED returns a read-back of a QLED field expected to become want.
+func (s *InverterSetter) verifyQLED(name string, field func(*QLEDData) float64, want float64) *settingReadBack {
+	return &settingReadBack{
+		source: "QLED",
+		name:   name,
+		read: func() (float64, error) {
+			qledData, err := queryLEDStatus(s.communicator, s.parser)
+			if err != nil {
+				return 0, err
+			}
+			return field(qledData), nil
+		},
+		want: want,
+	}
+}
+
+// applyLEDSetting sends a PLED* command, which carries no CRC, and confirms it through QLED.
+func (s *InverterSetter) applyLEDSetting(command string, readBack *settingReadBack) error {
+	return s.changeSetting(command, false, readBack)
+}
+
+// SetLEDEnabled enables or disables the LED ring (PLEDE).
+func (s *InverterSetter) SetLEDEnabled(enabled bool) error {
+	return s.applyLEDSetting("PLEDE"+boolDigit(enabled),
+		s.verifyQLED("LED enabled", func(q *QLEDData) float64 { return flagValue(q.Enabled) }, flagValue(enabled)))
+}
+
+// SetLEDSpeed sets the LED animation speed (PLEDS, 0: low, 1: medium, 2: fast).
+func (s *InverterSetter) SetLEDSpeed(speed int) error {
+	if err := validateIntRange("LED speed", speed, 0, 2, 1); err != nil {
+		return err
+	}
+	return s.applyLEDSetting(fmt.Sprintf("PLEDS%d", speed),
+		s.verifyQLED("LED speed", func(q *QLEDData) float64 { return float64(q.Speed) }, float64(speed)))
+}
+
+// SetLEDEffect sets the LED effect (PLEDM, 0: power cycling, 1: power wheel,
+// 2: power chasing, 3: solid on).
+func (s *InverterSetter) SetLEDEffect(effect int) error {
+	if err := validateIntRange("LED effect", effect, 0, 3, 1); err != nil {
+		return err
+	}
+	return s.applyLEDSetting(fmt.Sprintf("PLEDM%d", effect),
+		s.verifyQLED("LED effect", func(q *QLEDData) float64 { return float64(q.Effect) }, float64(effect)))
+}
+
+// SetLEDBrightness sets the LED brightness (PLEDB, 0: low, 1: medium, 2: high).
+func (s *InverterSetter) SetLEDBrightness(brightness int) error {
+	if err := validateIntRange("LED brightness", brightness, 0, 2, 1); err != nil {
+		return err
+	}
+	return s.applyLEDSetting(fmt.Sprintf("PLEDB%d", brightness),
+		s.verifyQLED("LED brightness", func(q *QLEDData) float64 { return float64(q.Brightness) }, float64(brightness)))
+}
+
+// SetLEDPresentation sets what the LED ring presents (PLEDD, 0: PV power,
+// 1: battery capacity, 2: load, 3: energy source, 4: battery charge/discharge).
+func (s *InverterSetter) SetLEDPresentation(presentation int) error {
+	if err := validateIntRange("LED presentation", presentation, 0, 4, 1); err != nil {
+		return err
+	}
+	return s.applyLEDSetting(fmt.Sprintf("PLEDD%d", presentation),
+		s.verifyQLED("LED presentation", func(q *QLEDData) float64 { return float64(q.Presentation) }, float64(presentation)))
+}
+
+// SetLEDColor sets the colour of one slot (PLEDC<n><aaabbbccc>, slot 1-3).
+func (s *InverterSetter) SetLEDColor(slot int, color LEDColor) error {
+	if err := validateIntRange("LED colour slot", slot, LEDColorLineMode, LEDColorBatteryMode, 1); err != nil {
+		return err
+	}
+	for _, channel := range []struct {
+		name  string
+		value int
+	}{{"red", color.Red}, {"green", color.Green}, {"blue", color.Blue}} {
+		if err := validateIntRange("LED "+channel.name, channel.value, 0, 255, 1); err != nil {
+			return err
+		}
+	}
+	return s.applyLEDSetting(fmt.Sprintf("PLEDC%d%03d%03d%03d", slot, color.Red, color.Green, color.Blue),
+		s.verifyQLED(fmt.Sprintf("LED colour %d (0xRRGGBB)", slot), func(q *QLEDData) float64 { return q.Colors[slot-1].Value() }, color.Value()))
+}
+
+// Value packs the colour as 0xRRGGBB, the form its read-back is compared and audited in.
+func (c LEDColor) Value() float64 {
+	return float64(c.Red<<16 | c.Green<<8 | c.Blue)
+}
+
+// ParseLEDColor parses an "r,g,b" colour such as "160,0,240".
+func ParseLEDColor(value string) (LEDColor, error) {
+	channels := strings.Split(value, ",")
+	if len(channels) != 3 {
+		return LEDColor{}, fmt.Errorf("invalid colour %q, expected r,g,b", value)
+	}
+	var rgb [3]int
+	for i, channel := range channels {
+		n, err := strconv.Atoi(strings.TrimSpace(channel))
+		if err != nil {
+			return LEDColor{}, fmt.Errorf("invalid colour %q: %w", value, err)
+		}
+		rgb[i] = n
+	}
+	return LEDColor{Red: rgb[0], Green: rgb[1], Blue: rgb[2]}, nil
+}
+
+// ledColorSetting adapts SetLEDColor for one slot to the registry.
+func ledColorSetting(slot int) func(*InverterSetter, int, string) error {
+	return func(s *InverterSetter, machine int, value string) error {
+		color, err := ParseLEDColor(value)
+		if err != nil {
+			return err
+		}
+		return s.SetLEDColor(slot, color)
+	}
+}
+
+// pollLEDStatus queries QLED and publishes it under led.
+func pollLEDStatus(communicator *InverterCommunicator, parser *InverterParser, publisher *MQTTPublisher) {
+	fmt.Println("\nSending QLED command...")
+	qledData, err := queryLEDStatus(communicator, parser)
+	if err != nil {
+		fmt.Printf("Error querying LED status: %v\n", err)
+		return
+	}
+	fmt.Printf("Parsed QLED Data: %+v\n", qledData)
+	err = publisher.PublishData(qledData, "led")
+	if err != nil {
+		fmt.Printf("Error publishing QLED data to MQTT: %v\n", err)
+	}
+}
+
+// runLEDCommand implements the `led` subcommand. Without flags it prints the
+// QLED status; each flag sends the matching PLED* command.
+func runLEDCommand(args []string) error {
+	fs, devicePtr := newSubcommandFlagSet("led")
+	var enabled optionalBool
+	fs.Var(&enabled, "enabled", "Enable or disable the LED ring (on/off)")
+	speedPtr := fs.Int("speed", -1, "LED speed (0: low, 1: medium, 2: fast)")
+	effectPtr := fs.Int("effect", -1, "LED effect (0: power cycling, 1: power wheel, 2: power chasing, 3: solid on)")
+	brightnessPtr := fs.Int("brightness", -1, "LED brightness (0: low, 1: medium, 2: high)")
+	presentationPtr := fs.Int("presentation", -1, "LED data presentation (0: PV power, 1: battery capacity, 2: load, 3: energy source, 4: battery charge/discharge)")
+	lineColorPtr := fs.String("line-color", "", "Line mode colour as r,g,b")
+	avrColorPtr := fs.String("avr-color", "", "AVR mode colour as r,g,b")
+	batteryColorPtr := fs.String("battery-color", "", "Battery mode colour as r,g,b")
+	fs.Parse(args)
+
+	// Parse colours up front so a typo does not leave the ring half configured
+	colors := map[int]LEDColor{}
+	for slot, value := range map[int]string{
+		LEDColorLineMode:    *lineColorPtr,
+		LEDColorAVRMode:     *avrColorPtr,
+		LEDColorBatteryMode: *batteryColorPtr,
+	} {
+		if value == "" {
+			continue
+		}
+		color, err := ParseLEDColor(value)
+		if err != nil {
+			return err
+		}
+		colors[slot] = color
+	}
+
+	communicator, err := openSubcommandDevice(*devicePtr)
+	if err != nil {
+		return err
+	}
+	defer communicator.CloseDevice()
+
+	parser := NewInverterParser()
+	setter := NewInverterSetter(communicator, parser)
+
+	steps := []struct {
+		apply bool
+		run   func() error
+	}{
+		{enabled.set, func() error { return setter.SetLEDEnabled(enabled.value) }},
+		{*speedPtr >= 0, func() error { return setter.SetLEDSpeed(*speedPtr) }},
+		{*effectPtr >= 0, func() error { return setter.SetLEDEffect(*effectPtr) }},
+		{*brightnessPtr >= 0, func() error { return setter.SetLEDBrightness(*brightnessPtr) }},
+		{*presentationPtr >= 0, func() error { return setter.SetLEDPresentation(*presentationPtr) }},
+	}
+	for slot := LEDColorLineMode; slot <= LEDColorBatteryMode; slot++ {
+		color, ok := colors[slot]
+		slot := slot
+		steps = append(steps, struct {
+			apply bool
+			run   func() error
+		}{ok, func() error { return setter.SetLEDColor(slot, color) }})
+	}
+	for _, step := range steps {
+		if !step.apply {
+			continue
+		}
+		if err := step.run(); err != nil {
+			return err
+		}
+		time.Sleep(300 * time.Millisecond)
+	}
+
+	qledData, err := queryLEDStatus(communicator, parser)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nLED status: %+v\n", qledData)
+	return nil
+}
@@ -10,10 +10,34 @@ import (
 )
 
 func main() {
+	// Subcommands (e.g. `tou`) run once instead of the polling loop
+	if len(os.Args) > 1 {
+		if subcommand, ok := subcommands[os.Args[1]]; ok {
+			if err := subcommand.Run(os.Args[2:]); err != nil {
+				fmt.Printf("Error running %s: %v\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	// Command-line arguments
+	flag.Usage = printUsage
 	devicePtr := flag.String("device", "/dev/hidraw4", "Path to the hidraw device")
 	intervalPtr := flag.Duration("interval", 2*time.Second, "Polling interval (e.g., 2s, 1m)")
 	debugPtr := flag.Bool("debug", false, "Enable debug mode to query extra commands")
+	scheduleIntervalPtr := flag.Duration("schedule-interval", 10*time.Minute, "How often to poll the QOPPT/QCHPT priority schedules")
+	energyIntervalPtr := flag.Duration("energy-interval", 5*time.Minute, "How often to poll the inverter energy counters (QE*/QL*)")
+	equalizationIntervalPtr := flag.Duration("equalization-interval", time.Minute, "How often to poll the QBEQI equalization status")
+	ledIntervalPtr := flag.Duration("led-interval", 5*time.Minute, "How often to poll the QLED LED ring status")
+	diagnosticsIntervalPtr := flag.Duration("diagnostics-interval", time.Minute, "How often to poll the QWFS/QBMS diagnostics")
+	timezonePtr := flag.String("timezone", "Local", "IANA timezone of the inverter clock (e.g., Africa/Johannesburg)")
+	clockIntervalPtr := flag.Duration("clock-interval", 10*time.Minute, "How often to check the inverter clock (QT) for drift")
+	clockSyncPtr := flag.Bool("clock-sync", false, "Automatically set the inverter clock (DAT) when drift exceeds -clock-drift-threshold")
+	clockDriftThresholdPtr := flag.Duration("clock-drift-threshold", 30*time.Second, "Maximum inverter clock drift before -clock-sync corrects it")
+	flag.BoolVar(&dryRunMode, "dry-run", false, "Print the setting commands that would be sent instead of writing them")
+	flag.StringVar(&auditLogPath, "audit-log", auditLogPath, "Append-only JSON lines log of every setting change; settings are not sent without it")
+	flag.StringVar(&batteryControlStatePath, "battery-control-state", batteryControlStatePath, "File tracking the PBATCD charge/discharge switches, which the inverter cannot report")
 	flag.Parse()
 
 	devicePath := *devicePtr
@@ -24,13 +48,23 @@ func main() {
 	if debugMode {
 		fmt.Println("** DEBUG MODE ENABLED **")
 	}
+	if dryRunMode {
+		fmt.Println("** DRY-RUN MODE: setting commands are printed, not sent **")
+	}
 
-	// Initialize communicator and parser
+	// Initialize communicator, parser and setter
 	communicator := NewInverterCommunicator(devicePath)
 	parser := NewInverterParser()
+	setter := NewInverterSetter(communicator, parser)
+
+	clockSync, err := NewClockSync(communicator, parser, setter.As(AuditOriginAutomation, "clock-sync"), *timezonePtr, *clockSyncPtr, *clockDriftThresholdPtr)
+	if err != nil {
+		fmt.Printf("Failed to configure clock monitoring: %v\n", err)
+		os.Exit(1)
+	}
 
 	// Open the device
-	err := communicator.OpenDevice()
+	err = communicator.OpenDevice()
 	if err != nil {
 		fmt.Printf("Failed to open device: %v\n", err)
 		if os.IsNotExist(err) {
@@ -66,6 +100,26 @@ func main() {
 
 	// Initialize MQTT Publisher
 	publisher := NewMQTTPublisher(mqttConfig)
+
+	// Optional disk-backed queue for samples published during broker outages
+	if mqttConfig.OfflineQueue.IsEnabled() {
+		offlineQueue, err := NewOfflineQueue(mqttConfig.OfflineQueue)
+		if err != nil {
+			fmt.Printf("Failed to open the offline queue: %v\n", err)
+			os.Exit(1)
+		}
+		publisher.SetOfflineQueue(offlineQueue)
+	}
+
+	// Optional change detection so unchanged samples are not republished every poll
+	if mqttConfig.ChangeFilter.IsEnabled() {
+		changeFilter, err := NewChangeFilter(mqttConfig.ChangeFilter)
+		if err != nil {
+			fmt.Printf("Invalid change_filter configuration: %v\n", err)
+			os.Exit(1)
+		}
+		publisher.SetChangeFilter(changeFilter)
+	}
 	err = publisher.Connect()
 	if err != nil {
 		fmt.Printf("Failed to connect to MQTT broker: %v\n", err)
@@ -73,14 +127,66 @@ func main() {
 	}
 	defer publisher.Disconnect()
 
-	// Main polling loop
-	for {
-		// Define a struct to hold command results
-		type CommandResult struct {
-			Response string
-			Err      error
+	// Optional external BMS bridge feeding the inverter via PBMS
+	var bmsBridge *BMSBridge
+	if mqttConfig.BMSBridge.IsEnabled() {
+		bmsBridge, err = NewBMSBridge(mqttConfig.BMSBridge, setter.As(AuditOriginAutomation, "bms-bridge"), publisher)
+		if err != nil {
+			fmt.Printf("Failed to configure BMS bridge: %v\n", err)
+			os.Exit(1)
 		}
+		fmt.Printf("BMS bridge enabled with the %s source.\n", mqttConfig.BMSBridge.Source)
+	}
 
+	// PBATCD charge/discharge switches, controlled over MQTT once listed in remote_settings.allowed
+	var batteryControl *BatteryControl
+	if mqttConfig.RemoteSettings.AllowsBatterySwitches() {
+		batteryControl, err = NewBatteryControl(mqttConfig.RemoteSettings, setter, publisher, batteryControlStatePath)
+		if err != nil {
+			fmt.Printf("Failed to subscribe to the battery control switches, continuing without them: %v\n", err)
+		} else {
+			batteryControl.PublishState()
+		}
+	}
+
+	// Settings changed over MQTT (<topic>/<devicename>/set/<setting>), limited to remote_settings.allowed
+	remoteSettings, err := NewRemoteSettings(mqttConfig.RemoteSettings, setter, publisher)
+	if err != nil {
+		fmt.Printf("Failed to subscribe to the setting topics: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Whether the inverter answers QPIGS, published next to the poller's own availability
+	inverterReachability := NewInverterReachability(publisher)
+
+	// Home Assistant discovery configs, published once the ratings are known
+	var discovery *HADiscovery
+	if mqttConfig.Discovery.IsEnabled() {
+		discovery = NewHADiscovery(mqttConfig, publisher)
+	}
+
+	// Latest QPIRI ratings, used to size the parallel (QPGSn) poll
+	var lastQPIRIData *QPIRIData
+
+	// Parallel units that answered the last QPGSn poll
+	var lastParallelUnits []*QPGSData
+
+	// Serial number, model and firmware, queried until the inverter answers
+	var deviceInfo *DeviceInfo
+
+	// Selectable charge currents, queried once per device and used to validate MNCHGC/MUCHGC
+	chargeCurrentOptions := setter.chargeCurrentOptions
+
+	// Time-of-use priority tables, equalization status, LED ring, diagnostics, energy counters and the inverter clock are polled on a slower cadence
+	var lastSchedulePoll time.Time
+	var lastClockCheck time.Time
+	var lastEqualizationPoll time.Time
+	var lastLEDPoll time.Time
+	var lastDiagnosticsPoll time.Time
+	var lastEnergyPoll time.Time
+
+	// Main polling loop
+	for {
 		// --- QPIGS Command ---
 		fmt.Println("\nSending QPIGS command...")
 		cmdChanQPIGS := make(chan CommandResult, 1)
@@ -93,8 +199,10 @@ func main() {
 		case res := <-cmdChanQPIGS:
 			if res.Err != nil {
 				fmt.Printf("Error sending QPIGS command: %v\n", res.Err)
+				inverterReachability.Report(false)
 			} else {
 				qpigSData, err := parser.ParseQPIGSResponse(res.Response)
+				inverterReachability.Report(err == nil)
 				if err != nil {
 					fmt.Printf("Error parsing QPIGS response: %v\n", err)
 				} else {
@@ -107,6 +215,7 @@ func main() {
 			}
 		case <-time.After(2 * time.Second):
 			fmt.Println("Error: Timeout waiting for QPIGS response after 2 seconds")
+			inverterReachability.Report(false)
 		}
 
 		time.Sleep(300 * time.Millisecond)
@@ -129,6 +238,7 @@ func main() {
 					fmt.Printf("Error parsing QPIRI response: %v\n", err)
 				} else {
 					fmt.Printf("Parsed QPIRI Data: %+v\n", qpiriData)
+					lastQPIRIData = qpiriData
 					err = publisher.PublishData(qpiriData, "rating")
 					if err != nil {
 						fmt.Printf("Error publishing QPIRI data to MQTT: %v\n", err)
@@ -198,6 +308,110 @@ func main() {
 			fmt.Println("Error: Timeout waiting for QPIWS response after 2 seconds")
 		}
 
+		// --- QPGSn Commands (parallel system) ---
+		if lastQPIRIData != nil && lastQPIRIData.ParallelMaxNumber > 0 {
+			time.Sleep(300 * time.Millisecond)
+			lastParallelUnits = pollParallelUnits(communicator, parser, publisher, lastQPIRIData.ParallelMaxNumber)
+		}
+
+		// --- QMCHGCR / QMUCHGCR Commands (until cached) ---
+		if chargeCurrentOptions.Get(devicePath) == nil {
+			time.Sleep(300 * time.Millisecond)
+			fmt.Println("\nSending QMCHGCR/QMUCHGCR commands...")
+			options, err := chargeCurrentOptions.Refresh(communicator, parser)
+			if err != nil {
+				fmt.Printf("Error querying selectable charge currents: %v\n", err)
+			} else {
+				fmt.Printf("Selectable charge currents: %+v\n", options)
+				err = publisher.PublishData(options, "charge_current_options")
+				if err != nil {
+					fmt.Printf("Error publishing charge current options to MQTT: %v\n", err)
+				}
+			}
+		}
+
+		// --- QID / QMN / QVFW Commands (until answered) ---
+		if deviceInfo == nil {
+			time.Sleep(300 * time.Millisecond)
+			deviceInfo = pollDeviceInfo(communicator, parser, publisher)
+		}
+
+		// --- Home Assistant discovery (when the configs change or after a reconnect) ---
+		if discovery != nil && deviceInfo != nil && lastQPIRIData != nil {
+			runDiscovery(discovery, *deviceInfo, lastQPIRIData, chargeCurrentOptions.Get(devicePath), lastParallelUnits)
+		}
+
+		// --- QOPPT / QCHPT Commands (slow cadence) ---
+		if time.Since(lastSchedulePoll) >= *scheduleIntervalPtr {
+			time.Sleep(300 * time.Millisecond)
+			pollPrioritySchedules(communicator, parser, publisher)
+			lastSchedulePoll = time.Now()
+		}
+
+		// --- QBEQI Command (slow cadence) ---
+		if time.Since(lastEqualizationPoll) >= *equalizationIntervalPtr {
+			time.Sleep(300 * time.Millisecond)
+			pollEqualization(communicator, parser, publisher)
+			lastEqualizationPoll = time.Now()
+		}
+
+		// --- QLED Command (slow cadence) ---
+		if time.Since(lastLEDPoll) >= *ledIntervalPtr {
+			time.Sleep(300 * time.Millisecond)
+			pollLEDStatus(communicator, parser, publisher)
+			lastLEDPoll = time.Now()
+		}
+
+		// --- PBMS BMS Bridge ---
+		if bmsBridge != nil && bmsBridge.Due() {
+			time.Sleep(300 * time.Millisecond)
+			runBMSBridge(bmsBridge, publisher)
+		}
+
+		// --- PBATCD Battery Control (queued MQTT switch changes) ---
+		if batteryControl != nil && batteryControl.Pending() {
+			time.Sleep(300 * time.Millisecond)
+			runBatteryControl(batteryControl)
+		}
+
+		// --- Settings received over MQTT ---
+		if remoteSettings.Pending() {
+			time.Sleep(300 * time.Millisecond)
+			runRemoteSettings(remoteSettings)
+		}
+
+		// --- QWFS / QBMS Diagnostics (slow cadence) ---
+		if time.Since(lastDiagnosticsPoll) >= *diagnosticsIntervalPtr {
+			time.Sleep(300 * time.Millisecond)
+			pollDiagnostics(communicator, parser, publisher)
+			lastDiagnosticsPoll = time.Now()
+		}
+
+		// --- QE* / QL* Energy Commands (slow cadence) ---
+		if time.Since(lastEnergyPoll) >= *energyIntervalPtr {
+			time.Sleep(300 * time.Millisecond)
+			pollEnergy(communicator, parser, publisher, clockSync.Location())
+			lastEnergyPoll = time.Now()
+		}
+
+		// --- QT Command (slow cadence, optional DAT sync) ---
+		if time.Since(lastClockCheck) >= *clockIntervalPtr {
+			time.Sleep(300 * time.Millisecond)
+			fmt.Println("\nSending QT command...")
+			clockStatus, err := clockSync.Check()
+			if err != nil {
+				fmt.Printf("Error checking inverter clock: %v\n", err)
+			}
+			if clockStatus != nil {
+				fmt.Printf("Inverter clock status: %+v\n", clockStatus)
+				err = publisher.PublishData(clockStatus, "clock")
+				if err != nil {
+					fmt.Printf("Error publishing clock status to MQTT: %v\n", err)
+				}
+			}
+			lastClockCheck = time.Now()
+		}
+
 		// --- Debug Commands ---
 		if debugMode {
 			time.Sleep(300 * time.Millisecond)
@@ -263,4 +477,36 @@ func main() {
 
 		time.Sleep(pollingInterval) // Wait for the next poll
 	}
-}	
\ No newline at end of file
+}	
+
+// CommandResult holds the outcome of a single inverter command.
+type CommandResult struct {
+	Response string
+	Err      error
+}
+
+// sendCommandWithTimeout sends a command on its own goroutine and waits at most
+// timeout for the response, so a stalled device cannot block the polling loop.
+func sendCommandWithTimeout(communicator *InverterCommunicator, command string, timeout time.Duration) (string, error) {
+	return runCommandWithTimeout(command, timeout, communicator.SendCommand)
+}
+
+// sendCommandNoCRCWithTimeout is sendCommandWithTimeout for commands without a CRC.
+func sendCommandNoCRCWithTimeout(communicator *InverterCommunicator, command string, timeout time.Duration) (string, error) {
+	return runCommandWithTimeout(command, timeout, communicator.SendCommandNoCRC)
+}
+
+func runCommandWithTimeout(command string, timeout time.Duration, send func(string) (string, error)) (string, error) {
+	cmdChan := make(chan CommandResult, 1)
+	go func() {
+		rawResponse, err := send(command)
+		cmdChan <- CommandResult{Response: rawResponse, Err: err}
+	}()
+
+	select {
+	case res := <-cmdChan:
+		return res.Response, res.Err
+	case <-time.After(timeout):
+		return "", fmt.Errorf("timeout waiting for %s response after %v", command, timeout)
+	}
+}
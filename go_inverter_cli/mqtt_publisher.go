@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"math/rand"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -14,30 +16,73 @@ import (
 type MQTTPublisher struct {
 	client mqtt.Client
 	config MQTTConfig
+
+	// Subscriptions are kept so they can be restored after an automatic reconnect
+	subscriptionsMu sync.Mutex
+	subscriptions   map[string]mqtt.MessageHandler
+
+	// Messages published while the broker is unreachable, replayed in order (optional)
+	offlineQueue *OfflineQueue
+
+	// Skips messages that have not changed since they were last published (optional)
+	changeFilter *ChangeFilter
+
+	// Counts successful connects, so retained state can be resent after a reconnect
+	connections uint32
 }
 
 // MQTTConfig holds the configuration for the MQTT connection.
 type MQTTConfig struct {
 	Server     string `json:"server"`
 	Port       string `json:"port"`
+	Scheme     string `json:"scheme"` // tcp (default), ssl, ws or wss
+	Path       string `json:"path"`   // WebSocket path for ws/wss, e.g. "/mqtt"
+	TLS        MQTTTLSConfig `json:"tls"`
 	Topic      string `json:"topic"`
 	DeviceName string `json:"devicename"`
 	Username   string `json:"username"`
 	Password   string `json:"password"`
 	ClientID   string `json:"clientid"`	
+	Influx     InfluxConfig `json:"influx"`
+	BMSBridge  BMSBridgeConfig `json:"bms_bridge"`
+	DestructiveCommands DestructiveCommandsConfig `json:"destructive_commands"`
+	RemoteSettings RemoteSettingsConfig `json:"remote_settings"`
+	Discovery  DiscoveryConfig `json:"discovery"`
+	Retain     RetainConfig `json:"retain"`
+	OfflineQueue OfflineQueueConfig `json:"offline_queue"`
+	TopicLayout string `json:"topic_layout"` // json (default), flat or both
+	FlatTopicTemplate string `json:"flat_topic"` // e.g. "{topic}/{device}/{subtopic}/{field}"
+	NamingMap  map[string]string `json:"namingMap"` // field (or "<subtopic>/<field>") to published name
+	ChangeFilter ChangeFilterConfig `json:"change_filter"`
 }
 
 // NewMQTTPublisher creates a new MQTT publisher instance.
 func NewMQTTPublisher(config MQTTConfig) *MQTTPublisher {
 	return &MQTTPublisher{
-		config: config,
+		config:        config,
+		subscriptions: make(map[string]mqtt.MessageHandler),
 	}
 }
 
 // Connect establishes a connection to the MQTT broker.
 func (mp *MQTTPublisher) Connect() error {
+	brokerURL, useTLS, err := mp.config.BrokerURL()
+	if err != nil {
+		return err
+	}
+	if _, err := mp.config.Layout(); err != nil {
+		return err
+	}
+
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s:%s", mp.config.Server, mp.config.Port))
+	opts.AddBroker(brokerURL)
+	if useTLS {
+		tlsConfig, err := mp.config.TLS.Build()
+		if err != nil {
+			return err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
 
 	rand.Seed(time.Now().UnixNano())
 	clientID := mp.config.ClientID + "_" + strconv.Itoa(rand.Intn(100000))
@@ -48,9 +93,24 @@ func (mp *MQTTPublisher) Connect() error {
 	opts.SetPingTimeout(5 * time.Second)
 	opts.SetAutoReconnect(true)
 
+	// The broker marks the poller offline if the connection drops without a clean disconnect
+	availabilityTopic := mp.Topic(availabilitySubTopic)
+	opts.SetWill(availabilityTopic, AvailabilityOffline, 1, true)
+
 	// Set up handlers for connection events
 	opts.SetOnConnectHandler(func(client mqtt.Client) {
 		fmt.Println("Connected to MQTT broker!")
+		atomic.AddUint32(&mp.connections, 1)
+		if token := client.Publish(availabilityTopic, 1, true, AvailabilityOnline); token.Wait() && token.Error() != nil {
+			fmt.Printf("Failed to publish availability to %s: %v\n", availabilityTopic, token.Error())
+		}
+		mp.subscriptionsMu.Lock()
+		defer mp.subscriptionsMu.Unlock()
+		for topic, handler := range mp.subscriptions {
+			if token := client.Subscribe(topic, 1, handler); token.Wait() && token.Error() != nil {
+				fmt.Printf("Failed to resubscribe to %s: %v\n", topic, token.Error())
+			}
+		}
 	})
 	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
 		fmt.Printf("MQTT connection lost: %v\n", err)
@@ -58,37 +118,176 @@ func (mp *MQTTPublisher) Connect() error {
 
 	mp.client = mqtt.NewClient(opts)
 	if token := mp.client.Connect(); token.Wait() && token.Error() != nil {
-		return fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+		return fmt.Errorf("failed to connect to MQTT broker %s: %w", brokerURL, token.Error())
 	}
 	return nil
 }
 
+// Connections returns how often the client has connected, reconnects included.
+func (mp *MQTTPublisher) Connections() uint32 {
+	return atomic.LoadUint32(&mp.connections)
+}
+
 // Disconnect closes the connection to the MQTT broker.
 func (mp *MQTTPublisher) Disconnect() {
 	if mp.client != nil && mp.client.IsConnected() {
+		// A clean disconnect does not trigger the last will
+		mp.client.Publish(mp.Topic(availabilitySubTopic), 1, true, AvailabilityOffline).WaitTimeout(time.Second)
 		mp.client.Disconnect(250) // Disconnect with 250ms grace period
 		fmt.Println("Disconnected from MQTT broker.")
 	}
 }
 
-// PublishData publishes structured data to a specific sub-topic.
-func (mp *MQTTPublisher) PublishData(data interface{}, subTopic string) error {
+// Subscribe subscribes to an absolute topic and keeps the subscription across reconnects.
+func (mp *MQTTPublisher) Subscribe(topic string, handler mqtt.MessageHandler) error {
 	if mp.client == nil || !mp.client.IsConnected() {
 		return fmt.Errorf("not connected to MQTT broker")
 	}
 
+	mp.subscriptionsMu.Lock()
+	mp.subscriptions[topic] = handler
+	mp.subscriptionsMu.Unlock()
+
+	token := mp.client.Subscribe(topic, 1, handler)
+	token.Wait()
+	if token.Error() != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", topic, token.Error())
+	}
+	fmt.Printf("Subscribed to topic %s\n", topic)
+	return nil
+}
+
+// Topic returns the absolute topic of a sub-topic: <topic>/<devicename>/<subTopic>.
+func (mp *MQTTPublisher) Topic(subTopic string) string {
+	return fmt.Sprintf("%s/%s/%s", mp.config.Topic, mp.config.DeviceName, subTopic)
+}
+
+// SetOfflineQueue makes PublishData queue messages instead of failing while
+// the broker is unreachable.
+func (mp *MQTTPublisher) SetOfflineQueue(queue *OfflineQueue) {
+	mp.offlineQueue = queue
+}
+
+// SetChangeFilter makes PublishData skip messages that have not changed.
+func (mp *MQTTPublisher) SetChangeFilter(filter *ChangeFilter) {
+	mp.changeFilter = filter
+}
+
+// isConnectionOpen reports whether messages can be sent right now. IsConnected
+// is also true while the client reconnects, when a QoS 1 publish would block.
+func (mp *MQTTPublisher) isConnectionOpen() bool {
+	return mp.client != nil && mp.client.IsConnectionOpen()
+}
+
+// publish sends one message and waits for the broker to acknowledge it. The
+// wait is bounded, so a connection lost mid-publish cannot stall polling.
+func (mp *MQTTPublisher) publish(topic string, retain bool, payload []byte) error {
+	token := mp.client.Publish(topic, 1, retain, payload)
+	if !token.WaitTimeout(10 * time.Second) {
+		return fmt.Errorf("timed out publishing to %s", topic)
+	}
+	if token.Error() != nil {
+		return fmt.Errorf("failed to publish message: %w", token.Error())
+	}
+	return nil
+}
+
+// PublishData publishes structured data to a specific sub-topic, as one JSON
+// object and/or one topic per field depending on the topic layout, with the
+// namingMap applied. Messages are retained if the retain setting of the
+// sub-topic's class says so. With a change filter, unchanged messages are
+// skipped, except on control topics, which always answer.
+func (mp *MQTTPublisher) PublishData(data interface{}, subTopic string) error {
 	payload, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("failed to marshal data to JSON: %w", err)
 	}
 
-	topic := fmt.Sprintf("%s/%s/%s", mp.config.Topic, mp.config.DeviceName, subTopic)
-	token := mp.client.Publish(topic, 1, false, payload)
+	class := TopicClass(subTopic)
+	retain := mp.config.Retain.Retain(class)
+	filter := mp.changeFilter
+	if class == TopicClassControl {
+		filter = nil
+	}
+	fields, splitErr := splitJSONObject(payload)
+	now := time.Now()
+
+	layout, _ := mp.config.Layout() // validated by Connect
+	if layout != TopicLayoutFlat {
+		topic := mp.Topic(subTopic)
+		if filter == nil || splitErr != nil || filter.Changed(topic, subTopic, fields, now) {
+			if err := mp.publishMessage(topic, retain, mp.config.renameJSONKeys(subTopic, payload)); err != nil {
+				return err
+			}
+			if filter != nil && splitErr == nil {
+				filter.Sent(topic, fields, now)
+			}
+		}
+	}
+	if layout != TopicLayoutJSON {
+		if splitErr != nil {
+			return fmt.Errorf("cannot publish %s per field: %w", subTopic, splitErr)
+		}
+		for _, field := range fields {
+			topic := mp.config.FlatTopic(subTopic, field.Key)
+			single := []jsonField{field}
+			if filter != nil && !filter.Changed(topic, subTopic, single, now) {
+				continue
+			}
+			if err := mp.publishMessage(topic, retain, flatValue(field.Value)); err != nil {
+				return err
+			}
+			if filter != nil {
+				filter.Sent(topic, single, now)
+			}
+		}
+	}
+	return nil
+}
+
+// publishMessage sends one message. With an offline queue, queued messages
+// are replayed first and the message is queued while the broker is unreachable.
+func (mp *MQTTPublisher) publishMessage(topic string, retain bool, payload []byte) error {
+	if mp.offlineQueue != nil {
+		if mp.isConnectionOpen() && mp.offlineQueue.Pending() {
+			sent, err := mp.offlineQueue.Replay(mp.publish)
+			if sent > 0 {
+				fmt.Printf("Replayed %d queued messages.\n", sent)
+			}
+			if err != nil {
+				fmt.Printf("Error replaying the offline queue: %v\n", err)
+			}
+		}
+		if !mp.isConnectionOpen() || mp.offlineQueue.Pending() {
+			if err := mp.offlineQueue.Enqueue(topic, retain, payload, time.Now()); err != nil {
+				return fmt.Errorf("not connected to MQTT broker and %w", err)
+			}
+			fmt.Printf("Queued for topic %s until the broker is reachable\n", topic)
+			return nil
+		}
+	} else if mp.client == nil || !mp.client.IsConnected() {
+		return fmt.Errorf("not connected to MQTT broker")
+	}
+
+	if err := mp.publish(topic, retain, payload); err != nil {
+		return err
+	}
+
+	fmt.Printf("Published to topic %s: %s\n", topic, payload)
+	return nil
+}
+
+// PublishRetained publishes a raw payload to an absolute topic with the retain
+// flag set. An empty payload clears the retained message.
+func (mp *MQTTPublisher) PublishRetained(topic string, payload []byte) error {
+	if mp.client == nil || !mp.client.IsConnected() {
+		return fmt.Errorf("not connected to MQTT broker")
+	}
+
+	token := mp.client.Publish(topic, 1, true, payload)
 	token.Wait()
 	if token.Error() != nil {
-		return fmt.Errorf("failed to publish message: %w", token.Error())
+		return fmt.Errorf("failed to publish message to %s: %w", topic, token.Error())
 	}
-
-	fmt.Printf("Published to topic %s: %s", topic, payload)
 	return nil
 }
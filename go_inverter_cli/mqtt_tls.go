@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// MQTTTLSConfig holds the "tls" section of the config file, used by the ssl
+// and wss schemes. Without a CA file the system roots verify the broker.
+type MQTTTLSConfig struct {
+	CAFile             string   `json:"ca_file"`              // PEM bundle of the CAs that sign the broker certificate
+	CertFile           string   `json:"cert_file"`            // PEM client certificate, for brokers that require one
+	KeyFile            string   `json:"key_file"`             // PEM private key of cert_file
+	InsecureSkipVerify bool     `json:"insecure_skip_verify"` // lab use only: accept any broker certificate
+	ALPN               []string `json:"alpn"`                 // protocols offered during the handshake, e.g. ["mqtt"]
+}
+
+// Build loads the files into a tls.Config.
+func (c MQTTTLSConfig) Build() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		NextProtos:         c.ALPN,
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file %s: %w", c.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in CA file %s", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, fmt.Errorf("tls.cert_file and tls.key_file must be set together")
+		}
+		certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate %s: %w", c.CertFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+// brokerSchemes lists the supported schemes and whether they use TLS.
+var brokerSchemes = map[string]bool{
+	"tcp": false,
+	"ws":  false,
+	"ssl": true,
+	"wss": true,
+}
+
+// BrokerURL returns <scheme>://<server>:<port>[<path>]. The scheme defaults to
+// tcp; the path only applies to ws and wss, e.g. "/mqtt".
+func (c MQTTConfig) BrokerURL() (string, bool, error) {
+	scheme := strings.ToLower(strings.TrimSpace(c.Scheme))
+	if scheme == "" {
+		scheme = "tcp"
+	}
+	useTLS, ok := brokerSchemes[scheme]
+	if !ok {
+		return "", false, fmt.Errorf("unsupported MQTT scheme %q, expected tcp, ssl, ws or wss", c.Scheme)
+	}
+
+	brokerURL := fmt.Sprintf("%s://%s:%s", scheme, c.Server, c.Port)
+	if scheme == "ws" || scheme == "wss" {
+		path := c.Path
+		if path != "" && !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		brokerURL += path
+	}
+	return brokerURL, useTLS, nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCertificate is a generated certificate with its key, in memory and as PEM files.
+type testCertificate struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	tls      tls.Certificate
+	certFile string
+	keyFile  string
+}
+
+// newTestCertificate creates a certificate signed by parent, or a self-signed
+// CA when parent is nil.
+func newTestCertificate(t *testing.T, dir, name string, parent *testCertificate, usage x509.ExtKeyUsage) *testCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("generate serial: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("create certificate %s: %v", name, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate %s: %v", name, err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key %s: %v", name, err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("key pair %s: %v", name, err)
+	}
+	generated := &testCertificate{
+		cert:     cert,
+		key:      key,
+		tls:      pair,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	if err := os.WriteFile(generated.certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(generated.keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return generated
+}
+
+// testPKI is a CA with a server and a client certificate.
+type testPKI struct {
+	ca, server, client *testCertificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, dir, "ca", nil, 0)
+	return &testPKI{
+		ca:     ca,
+		server: newTestCertificate(t, dir, "server", ca, x509.ExtKeyUsageServerAuth),
+		client: newTestCertificate(t, dir, "client", ca, x509.ExtKeyUsageClientAuth),
+	}
+}
+
+// testBroker is a TLS listener that requires a client certificate signed by
+// the test CA and speaks just enough MQTT 3.1.1 for Connect and Disconnect.
+type testBroker struct {
+	listener net.Listener
+	states   chan tls.ConnectionState
+}
+
+func startTestBroker(t *testing.T, pki *testPKI, alpn []string) *testBroker {
+	t.Helper()
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(pki.ca.cert)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{pki.server.tls},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		NextProtos:   alpn,
+	})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	broker := &testBroker{listener: listener, states: make(chan tls.ConnectionState, 8)}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go broker.serve(conn.(*tls.Conn))
+		}
+	}()
+	return broker
+}
+
+func (b *testBroker) port() string {
+	return strings.TrimPrefix(b.listener.Addr().String(), "127.0.0.1:")
+}
+
+// serve completes the handshake, reports its state and answers CONNECT,
+// QoS 1 PUBLISH, SUBSCRIBE and PINGREQ until the client disconnects.
+func (b *testBroker) serve(conn *tls.Conn) {
+	defer conn.Close()
+	if err := conn.Handshake(); err != nil {
+		return
+	}
+	b.states <- conn.ConnectionState()
+
+	reader := bufio.NewReader(conn)
+	for {
+		header, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+		length, multiplier := 0, 1
+		for {
+			digit, err := reader.ReadByte()
+			if err != nil {
+				return
+			}
+			length += int(digit&0x7f) * multiplier
+			multiplier *= 128
+			if digit&0x80 == 0 {
+				break
+			}
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return
+		}
+
+		var reply []byte
+		switch header >> 4 {
+		case 1: // CONNECT
+			reply = []byte{0x20, 0x02, 0x00, 0x00}
+		case 3: // PUBLISH
+			if qos := (header >> 1) & 0x03; qos == 1 {
+				topicLength := int(body[0])<<8 | int(body[1])
+				id := body[2+topicLength : 4+topicLength]
+				reply = []byte{0x40, 0x02, id[0], id[1]}
+			}
+		case 8: // SUBSCRIBE
+			reply = []byte{0x90, 0x03, body[0], body[1], 0x01}
+		case 12: // PINGREQ
+			reply = []byte{0xd0, 0x00}
+		case 14: // DISCONNECT
+			return
+		}
+		if reply != nil {
+			if _, err := conn.Write(reply); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handshake dials the broker with a config built from tlsConfig.
+func handshake(t *testing.T, broker *testBroker, tlsConfig MQTTTLSConfig) (tls.ConnectionState, error) {
+	t.Helper()
+	config, err := tlsConfig.Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	conn, err := tls.Dial("tcp", broker.listener.Addr().String(), config)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	defer conn.Close()
+	return conn.ConnectionState(), nil
+}
+
+func TestMQTTTLSConfigVerifiesBrokerWithCABundle(t *testing.T) {
+	pki := newTestPKI(t)
+	broker := startTestBroker(t, pki, nil)
+	clientCert := MQTTTLSConfig{CertFile: pki.client.certFile, KeyFile: pki.client.keyFile}
+
+	// The test CA is not a system root
+	if _, err := handshake(t, broker, clientCert); err == nil {
+		t.Fatal("handshake without ca_file succeeded against a private CA")
+	}
+
+	withCA := clientCert
+	withCA.CAFile = pki.ca.certFile
+	state, err := handshake(t, broker, withCA)
+	if err != nil {
+		t.Fatalf("handshake with ca_file: %v", err)
+	}
+	if got := state.PeerCertificates[0].Subject.CommonName; got != "server" {
+		t.Errorf("verified broker certificate %q, want server", got)
+	}
+}
+
+func TestMQTTTLSConfigPresentsClientCertificate(t *testing.T) {
+	pki := newTestPKI(t)
+	broker := startTestBroker(t, pki, nil)
+
+	// The broker rejects the handshake without a client certificate; TLS 1.3
+	// reports that on the first read rather than in Dial
+	config, err := MQTTTLSConfig{CAFile: pki.ca.certFile}.Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	conn, err := tls.Dial("tcp", broker.listener.Addr().String(), config)
+	if err == nil {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	if err == nil {
+		t.Fatal("broker accepted a client without a certificate")
+	}
+
+	if _, err := handshake(t, broker, MQTTTLSConfig{CAFile: pki.ca.certFile, CertFile: pki.client.certFile, KeyFile: pki.client.keyFile}); err != nil {
+		t.Fatalf("handshake with client certificate: %v", err)
+	}
+	select {
+	case state := <-broker.states:
+		if len(state.PeerCertificates) == 0 || state.PeerCertificates[0].Subject.CommonName != "client" {
+			t.Errorf("broker saw client certificates %v, want client", state.PeerCertificates)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("broker did not complete the handshake")
+	}
+
+	if _, err := (MQTTTLSConfig{CertFile: pki.client.certFile}).Build(); err == nil {
+		t.Error("Build accepted cert_file without key_file")
+	}
+}
+
+func TestMQTTTLSConfigNegotiatesALPN(t *testing.T) {
+	pki := newTestPKI(t)
+	broker := startTestBroker(t, pki, []string{"mqtt"})
+	config := MQTTTLSConfig{CAFile: pki.ca.certFile, CertFile: pki.client.certFile, KeyFile: pki.client.keyFile}
+
+	config.ALPN = []string{"x-unknown", "mqtt"}
+	state, err := handshake(t, broker, config)
+	if err != nil {
+		t.Fatalf("handshake with ALPN: %v", err)
+	}
+	if state.NegotiatedProtocol != "mqtt" {
+		t.Errorf("negotiated protocol %q, want mqtt", state.NegotiatedProtocol)
+	}
+
+	config.ALPN = []string{"x-unknown"}
+	if _, err := handshake(t, broker, config); err == nil {
+		t.Error("handshake succeeded without a common ALPN protocol")
+	}
+}
+
+func TestMQTTPublisherConnectsOverSSL(t *testing.T) {
+	pki := newTestPKI(t)
+	broker := startTestBroker(t, pki, []string{"mqtt"})
+
+	publisher := NewMQTTPublisher(MQTTConfig{
+		Server:     "127.0.0.1",
+		Port:       broker.port(),
+		Scheme:     "ssl",
+		Topic:      "test",
+		DeviceName: "inverter",
+		ClientID:   "tls-test",
+		TLS: MQTTTLSConfig{
+			CAFile:   pki.ca.certFile,
+			CertFile: pki.client.certFile,
+			KeyFile:  pki.client.keyFile,
+			ALPN:     []string{"mqtt"},
+		},
+	})
+	if err := publisher.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer publisher.Disconnect()
+
+	select {
+	case state := <-broker.states:
+		if state.NegotiatedProtocol != "mqtt" {
+			t.Errorf("negotiated protocol %q, want mqtt", state.NegotiatedProtocol)
+		}
+		if len(state.PeerCertificates) == 0 || state.PeerCertificates[0].Subject.CommonName != "client" {
+			t.Errorf("broker saw client certificates %v, want client", state.PeerCertificates)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("broker did not complete the handshake")
+	}
+	if err := publisher.PublishData(map[string]int{"BatteryCapacity": 80}, "state"); err != nil {
+		t.Errorf("PublishData over ssl: %v", err)
+	}
+}
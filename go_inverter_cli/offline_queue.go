@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OfflineQueueConfig holds the "offline_queue" section of the config file.
+type OfflineQueueConfig struct {
+	Enabled            string `json:"enabled"`
+	Path               string `json:"path"`                // JSON lines file, e.g. "/app/mqtt_queue.jsonl"
+	MaxBytes           int64  `json:"max_bytes"`           // the oldest samples are dropped beyond this size
+	MaxAge             string `json:"max_age"`             // samples older than this are dropped, e.g. "24h"
+	OriginalTimestamps string `json:"original_timestamps"` // "true" adds the sample time to replayed payloads
+}
+
+// IsEnabled reports whether the queue is switched on ("enabled": "true").
+func (c OfflineQueueConfig) IsEnabled() bool {
+	return strings.EqualFold(c.Enabled, "true")
+}
+
+// queuedMessage is one line of the queue file. The payload is stored as
+// base64, since flat-layout values such as "L" are not JSON.
+type queuedMessage struct {
+	Queued  time.Time `json:"queued"`
+	Topic   string    `json:"topic"`
+	Retain  bool      `json:"retain"`
+	Payload []byte    `json:"payload"`
+}
+
+// OfflineQueue keeps the messages published while the broker is unreachable
+// in a file, so they survive a restart, and hands them back in order. The
+// file never grows past maxBytes and holds nothing older than maxAge.
+type OfflineQueue struct {
+	path               string
+	maxBytes           int64
+	maxAge             time.Duration
+	originalTimestamps bool
+	mu                 sync.Mutex
+	size               int64 // current file size
+}
+
+// NewOfflineQueue opens the queue, picking up messages left by a previous run.
+func NewOfflineQueue(config OfflineQueueConfig) (*OfflineQueue, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("offline_queue.path must be set")
+	}
+	if config.MaxBytes <= 0 {
+		return nil, fmt.Errorf("offline_queue.max_bytes must be positive, got %d", config.MaxBytes)
+	}
+	maxAge, err := time.ParseDuration(config.MaxAge)
+	if err != nil {
+		return nil, fmt.Errorf("invalid offline_queue max_age %q: %w", config.MaxAge, err)
+	}
+	if maxAge <= 0 {
+		return nil, fmt.Errorf("offline_queue.max_age must be positive, got %s", maxAge)
+	}
+
+	queue := &OfflineQueue{
+		path:               config.Path,
+		maxBytes:           config.MaxBytes,
+		maxAge:             maxAge,
+		originalTimestamps: strings.EqualFold(config.OriginalTimestamps, "true"),
+	}
+	info, err := os.Stat(config.Path)
+	if err == nil {
+		queue.size = info.Size()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to open offline queue %s: %w", config.Path, err)
+	}
+	return queue, nil
+}
+
+// Pending reports whether messages are waiting to be replayed.
+func (q *OfflineQueue) Pending() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size > 0
+}
+
+// Enqueue appends a message and trims the file back to the limits.
+func (q *OfflineQueue) Enqueue(topic string, retain bool, payload []byte, queued time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	line, err := json.Marshal(queuedMessage{Queued: queued, Topic: topic, Retain: retain, Payload: payload})
+	if err != nil {
+		return fmt.Errorf("failed to marshal queued message: %w", err)
+	}
+	line = append(line, '\n')
+	if int64(len(line)) > q.maxBytes {
+		return fmt.Errorf("message for %s is larger than offline_queue.max_bytes", topic)
+	}
+
+	file, err := os.OpenFile(q.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open offline queue %s: %w", q.path, err)
+	}
+	_, err = file.Write(line)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write offline queue %s: %w", q.path, err)
+	}
+	q.size += int64(len(line))
+
+	if q.size > q.maxBytes {
+		return q.compact(time.Now())
+	}
+	return nil
+}
+
+// Replay publishes the queued messages oldest first and stops at the first
+// failure, keeping that message and the ones after it. Expired messages are
+// dropped. It returns how many messages were sent.
+func (q *OfflineQueue) Replay(publish func(topic string, retain bool, payload []byte) error) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	messages, err := q.load()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	sent := 0
+	for i, message := range messages {
+		if now.Sub(message.Queued) > q.maxAge {
+			continue
+		}
+		payload := message.Payload
+		if q.originalTimestamps {
+			payload = withTimestamp(payload, message.Queued)
+		}
+		if err := publish(message.Topic, message.Retain, payload); err != nil {
+			if rewriteErr := q.rewrite(q.unexpired(messages[i:], now)); rewriteErr != nil {
+				return sent, rewriteErr
+			}
+			return sent, err
+		}
+		sent++
+	}
+	return sent, q.rewrite(nil)
+}
+
+// load reads the queue file. Lines that do not parse, e.g. one torn by a
+// power cut, are skipped.
+func (q *OfflineQueue) load() ([]queuedMessage, error) {
+	file, err := os.Open(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read offline queue %s: %w", q.path, err)
+	}
+	defer file.Close()
+
+	var messages []queuedMessage
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), int(q.maxBytes)+1)
+	for scanner.Scan() {
+		var message queuedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			fmt.Printf("Offline queue: skipping unreadable line: %v\n", err)
+			continue
+		}
+		messages = append(messages, message)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read offline queue %s: %w", q.path, err)
+	}
+	return messages, nil
+}
+
+// unexpired returns the messages younger than maxAge.
+func (q *OfflineQueue) unexpired(messages []queuedMessage, now time.Time) []queuedMessage {
+	var kept []queuedMessage
+	for _, message := range messages {
+		if now.Sub(message.Queued) <= q.maxAge {
+			kept = append(kept, message)
+		}
+	}
+	return kept
+}
+
+// compactTarget is the share of maxBytes a full queue is trimmed to, so the
+// file is not rewritten for every sample once it is full.
+const compactTarget = 0.75
+
+// compact drops the expired messages, then the oldest ones until the file is
+// back under compactTarget of maxBytes.
+func (q *OfflineQueue) compact(now time.Time) error {
+	messages, err := q.load()
+	if err != nil {
+		return err
+	}
+	kept := q.unexpired(messages, now)
+
+	lines := make([][]byte, len(kept))
+	var total int64
+	for i, message := range kept {
+		lines[i], _ = json.Marshal(message)
+		total += int64(len(lines[i]) + 1)
+	}
+	target := int64(float64(q.maxBytes) * compactTarget)
+	first := 0
+	for total > target && first < len(kept) {
+		total -= int64(len(lines[first]) + 1)
+		first++
+	}
+	if dropped := len(messages) - len(kept) + first; dropped > 0 {
+		fmt.Printf("Offline queue: dropped %d messages to stay within max_bytes/max_age\n", dropped)
+	}
+	return q.rewrite(kept[first:])
+}
+
+// rewrite replaces the queue file with messages, or removes it when empty.
+func (q *OfflineQueue) rewrite(messages []queuedMessage) error {
+	if len(messages) == 0 {
+		if err := os.Remove(q.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to clear offline queue %s: %w", q.path, err)
+		}
+		q.size = 0
+		return nil
+	}
+
+	var content []byte
+	for _, message := range messages {
+		line, err := json.Marshal(message)
+		if err != nil {
+			return fmt.Errorf("failed to marshal queued message: %w", err)
+		}
+		content = append(append(content, line...), '\n')
+	}
+	tmpPath := q.path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0644); err != nil {
+		return fmt.Errorf("failed to write offline queue %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, q.path); err != nil {
+		return fmt.Errorf("failed to write offline queue %s: %w", q.path, err)
+	}
+	q.size = int64(len(content))
+	return nil
+}
+
+// withTimestamp adds the sample time as "timestamp" to a JSON object payload
+// that does not carry one yet.
+func withTimestamp(payload []byte, at time.Time) []byte {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(payload, &fields) != nil {
+		return payload
+	}
+	if _, ok := fields["timestamp"]; ok {
+		return payload
+	}
+	fields["timestamp"], _ = json.Marshal(at)
+	stamped, err := json.Marshal(fields)
+	if err != nil {
+		return payload
+	}
+	return stamped
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type replayedMessage struct {
+	topic   string
+	retain  bool
+	payload string
+}
+
+func newTestOfflineQueue(t *testing.T) *OfflineQueue {
+	t.Helper()
+	queue, err := NewOfflineQueue(OfflineQueueConfig{
+		Path:               filepath.Join(t.TempDir(), "queue.jsonl"),
+		MaxBytes:           1 << 20,
+		MaxAge:             "1h",
+		OriginalTimestamps: "true",
+	})
+	if err != nil {
+		t.Fatalf("NewOfflineQueue: %v", err)
+	}
+	return queue
+}
+
+func replayAll(t *testing.T, queue *OfflineQueue) []replayedMessage {
+	t.Helper()
+	var replayed []replayedMessage
+	sent, err := queue.Replay(func(topic string, retain bool, payload []byte) error {
+		replayed = append(replayed, replayedMessage{topic, retain, string(payload)})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if sent != len(replayed) {
+		t.Fatalf("Replay reported %d messages, published %d", sent, len(replayed))
+	}
+	if queue.Pending() {
+		t.Fatalf("queue still pending after a full replay")
+	}
+	return replayed
+}
+
+func TestOfflineQueueRoundTripsNonJSONPayloads(t *testing.T) {
+	queue := newTestOfflineQueue(t)
+	payloads := []string{"L", "00010110", "", "229.8", `{"GridVoltage":229.8}`}
+	for _, payload := range payloads {
+		if err := queue.Enqueue("solar/inv/state", true, []byte(payload), time.Now()); err != nil {
+			t.Fatalf("Enqueue(%q): %v", payload, err)
+		}
+	}
+
+	replayed := replayAll(t, queue)
+	if len(replayed) != len(payloads) {
+		t.Fatalf("replayed %d messages, want %d", len(replayed), len(payloads))
+	}
+	for i, payload := range payloads[:4] {
+		if replayed[i].payload != payload || !replayed[i].retain {
+			t.Errorf("message %d = %+v, want payload %q retained", i, replayed[i], payload)
+		}
+	}
+	// Only JSON objects get the sample time added
+	if !strings.HasPrefix(replayed[4].payload, `{"GridVoltage":229.8,"timestamp":`) {
+		t.Errorf("JSON payload = %s, want the original fields and a timestamp", replayed[4].payload)
+	}
+}
+
+func TestPublishDataQueuesFlatLayoutWhileDisconnected(t *testing.T) {
+	queue := newTestOfflineQueue(t)
+	publisher := NewMQTTPublisher(MQTTConfig{
+		Topic:       "solar",
+		DeviceName:  "inv",
+		TopicLayout: TopicLayoutFlat,
+		NamingMap:   map[string]string{"state/DeviceMode": "Inverter_mode"},
+	})
+	publisher.SetOfflineQueue(queue)
+
+	data := struct {
+		DeviceMode    string
+		DeviceStatus1 string
+		Warning       string
+		GridVoltage   float64
+		LoadStatusOn  bool
+	}{"L", "00010110", "", 229.8, true}
+	if err := publisher.PublishData(data, "state"); err != nil {
+		t.Fatalf("PublishData: %v", err)
+	}
+
+	want := []replayedMessage{
+		{"solar/inv/state/Inverter_mode", false, "L"},
+		{"solar/inv/state/DeviceStatus1", false, "00010110"},
+		{"solar/inv/state/Warning", false, ""},
+		{"solar/inv/state/GridVoltage", false, "229.8"},
+		{"solar/inv/state/LoadStatusOn", false, "true"},
+	}
+	replayed := replayAll(t, queue)
+	if len(replayed) != len(want) {
+		t.Fatalf("replayed %+v, want %+v", replayed, want)
+	}
+	for i := range want {
+		if replayed[i] != want[i] {
+			t.Errorf("message %d = %+v, want %+v", i, replayed[i], want[i])
+		}
+	}
+}
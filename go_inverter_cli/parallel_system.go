@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// ParallelSystemData holds the aggregated view of all units in a parallel system.
+type ParallelSystemData struct {
+	UnitCount                    int
+	FaultedUnits                 int
+	TotalLoadActivePower         int
+	TotalLoadApparentPower       int
+	TotalPVPower                 float64
+	TotalBatteryChargeCurrent    int
+	TotalBatteryDischargeCurrent int
+}
+
+// AggregateParallelData combines the per-unit QPGSn records into a system view.
+// Units that the inverter reports as not existing are skipped.
+func AggregateParallelData(units []*QPGSData) *ParallelSystemData {
+	system := &ParallelSystemData{}
+	for _, unit := range units {
+		if unit == nil || !unit.UnitExists {
+			continue
+		}
+		system.UnitCount++
+		if unit.FaultCode != 0 {
+			system.FaultedUnits++
+		}
+		system.TotalLoadActivePower += unit.ACOutputActivePower
+		system.TotalLoadApparentPower += unit.ACOutputApparentPower
+		system.TotalPVPower += unit.PV1InputVoltage*float64(unit.PV1InputCurrent) +
+			unit.PV2InputVoltage*float64(unit.PV2InputCurrent)
+		system.TotalBatteryChargeCurrent += unit.BatteryChargingCurrent
+		system.TotalBatteryDischargeCurrent += unit.BatteryDischargeCurrent
+	}
+	return system
+}
+
+// pollParallelUnits queries QPGS0..QPGS(count-1), publishes every existing unit
+// under parallel/<n> and the aggregated view under parallel/system, and
+// returns the units that answered.
+func pollParallelUnits(communicator *InverterCommunicator, parser *InverterParser, publisher *MQTTPublisher, count int) []*QPGSData {
+	var units []*QPGSData
+	for i := 0; i < count; i++ {
+		command := fmt.Sprintf("QPGS%d", i)
+		fmt.Printf("\nSending %s command...\n", command)
+		rawResponse, err := sendCommandWithTimeout(communicator, command, 2*time.Second)
+		time.Sleep(300 * time.Millisecond)
+		if err != nil {
+			fmt.Printf("Error sending %s command: %v\n", command, err)
+			continue
+		}
+
+		unitData, err := parser.ParseQPGSResponse(rawResponse, i)
+		if err != nil {
+			fmt.Printf("Error parsing %s response: %v\n", command, err)
+			continue
+		}
+		if !unitData.UnitExists {
+			continue
+		}
+
+		fmt.Printf("Parsed %s Data: %+v\n", command, unitData)
+		units = append(units, unitData)
+		err = publisher.PublishData(unitData, fmt.Sprintf("parallel/%d", i))
+		if err != nil {
+			fmt.Printf("Error publishing %s data to MQTT: %v\n", command, err)
+		}
+	}
+
+	if len(units) == 0 {
+		return nil
+	}
+
+	systemData := AggregateParallelData(units)
+	fmt.Printf("Aggregated parallel system data: %+v\n", systemData)
+	err := publisher.PublishData(systemData, "parallel/system")
+	if err != nil {
+		fmt.Printf("Error publishing parallel system data to MQTT: %v\n", err)
+	}
+	return units
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// RemoteSettingsConfig holds the "remote_settings" section of the config file:
+// the registry settings that may be changed over MQTT. An empty list keeps
+// every setting read-only.
+type RemoteSettingsConfig struct {
+	Allowed []string `json:"allowed"` // setting names as listed by set -list
+}
+
+// IsAllowed reports whether the setting is listed in Allowed.
+func (c RemoteSettingsConfig) IsAllowed(name string) bool {
+	for _, allowed := range c.Allowed {
+		if strings.TrimSpace(allowed) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteSettingRequest is one message received on set/<setting>.
+type remoteSettingRequest struct {
+	Setting  string          `json:"-"`
+	Topic    string          `json:"-"`
+	RawValue json.RawMessage `json:"value"`
+	Value    string          `json:"-"`
+	Machine  int             `json:"machine"`
+	ClientID string          `json:"client_id"`
+	Invalid  string          `json:"-"` // why the payload could not be parsed
+}
+
+// parseRemoteSettingPayload accepts the bare value ("2", "60", "on") or
+// {"value": 60, "machine": 1, "client_id": "ha-automation"}.
+func parseRemoteSettingPayload(payload []byte) (remoteSettingRequest, error) {
+	text := strings.TrimSpace(string(payload))
+	request := remoteSettingRequest{Value: text}
+	if !strings.HasPrefix(text, "{") {
+		return request, nil
+	}
+	if err := json.Unmarshal([]byte(text), &request); err != nil {
+		return request, fmt.Errorf("invalid JSON payload: %w", err)
+	}
+	if len(request.RawValue) == 0 {
+		return request, fmt.Errorf("JSON payload has no value")
+	}
+	var quoted string
+	if json.Unmarshal(request.RawValue, &quoted) == nil {
+		request.Value = quoted
+	} else {
+		request.Value = string(request.RawValue)
+	}
+	return request, nil
+}
+
+// RemoteSettingResult is published on set/<setting>/result for every request.
+type RemoteSettingResult struct {
+	Setting   string    `json:"setting"`
+	Value     string    `json:"value"`
+	Machine   int       `json:"machine"`
+	Result    string    `json:"result"` // ok, dry_run, rejected or error
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// RemoteSettings routes <topic>/<devicename>/set/<setting> messages through the
+// setting registry. Requests are queued and applied by the polling loop, so
+// they never interleave with its own commands on the device.
+type RemoteSettings struct {
+	config    RemoteSettingsConfig
+	setter    *InverterSetter
+	publisher *MQTTPublisher
+	mu        sync.Mutex
+	pending   []remoteSettingRequest
+}
+
+// NewRemoteSettings subscribes to the set topics.
+func NewRemoteSettings(config RemoteSettingsConfig, setter *InverterSetter, publisher *MQTTPublisher) (*RemoteSettings, error) {
+	remote := &RemoteSettings{config: config, setter: setter, publisher: publisher}
+	err := publisher.Subscribe(publisher.Topic("set/+"), func(client mqtt.Client, msg mqtt.Message) {
+		request, err := parseRemoteSettingPayload(msg.Payload())
+		if err != nil {
+			// Still queued, so the rejection is published from the polling loop
+			request.Invalid = err.Error()
+		}
+		request.Setting = path.Base(msg.Topic())
+		request.Topic = msg.Topic()
+		remote.queue(request)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return remote, nil
+}
+
+// maxPendingRemoteSettings caps the queue, as every request costs the polling
+// loop a write and a read-back on the device.
+const maxPendingRemoteSettings = 16
+
+// queue adds a request, replacing one queued for the same setting and machine
+// so the last value wins. Requests for further settings are dropped once the
+// queue is full.
+func (r *RemoteSettings) queue(request remoteSettingRequest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, queued := range r.pending {
+		if queued.Setting == request.Setting && queued.Machine == request.Machine {
+			r.pending[i] = request
+			return
+		}
+	}
+	if len(r.pending) >= maxPendingRemoteSettings {
+		fmt.Printf("Remote settings: ignoring %s, %d requests are already queued\n", request.Topic, len(r.pending))
+		return
+	}
+	r.pending = append(r.pending, request)
+}
+
+// Pending reports whether requests are waiting to be applied.
+func (r *RemoteSettings) Pending() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.pending) > 0
+}
+
+// Apply validates one request against the allow-list and the registry and
+// runs it through the typed setter, audited as an MQTT change.
+func (r *RemoteSettings) Apply(request remoteSettingRequest) RemoteSettingResult {
+	result := RemoteSettingResult{Setting: request.Setting, Value: request.Value, Machine: request.Machine, Timestamp: time.Now()}
+	reject := func(format string, args ...interface{}) RemoteSettingResult {
+		result.Result = "rejected"
+		result.Error = fmt.Sprintf(format, args...)
+		return result
+	}
+
+	if request.Invalid != "" {
+		return reject("%s", request.Invalid)
+	}
+	definition, ok := LookupSetting(request.Setting)
+	if !ok {
+		return reject("unknown setting %q", request.Setting)
+	}
+	if !r.config.IsAllowed(definition.Name) {
+		return reject("setting %s is not in remote_settings.allowed", definition.Name)
+	}
+	if request.Machine != 0 && !definition.Parallel {
+		return reject("setting %s does not take a parallel machine number", definition.Name)
+	}
+
+	clientID := request.ClientID
+	if clientID == "" {
+		clientID = request.Topic
+	}
+	if err := definition.Apply(r.setter.As(AuditOriginMQTT, clientID), request.Machine, request.Value); err != nil {
+		result.Result = "error"
+		result.Error = err.Error()
+		return result
+	}
+	result.Result = "ok"
+	if r.setter.dryRun {
+		result.Result = "dry_run"
+	}
+	return result
+}
+
+// runRemoteSettings applies the queued requests and publishes their results.
+func runRemoteSettings(remote *RemoteSettings) {
+	remote.mu.Lock()
+	requests := remote.pending
+	remote.pending = nil
+	remote.mu.Unlock()
+
+	for i, request := range requests {
+		if i > 0 {
+			time.Sleep(300 * time.Millisecond)
+		}
+		fmt.Printf("\nApplying MQTT setting %s = %s...\n", request.Setting, request.Value)
+		result := remote.Apply(request)
+		fmt.Printf("MQTT Setting Result: %+v\n", result)
+		if err := remote.publisher.PublishData(result, "set/"+request.Setting+"/result"); err != nil {
+			fmt.Printf("Error publishing setting result to MQTT: %v\n", err)
+		}
+	}
+}
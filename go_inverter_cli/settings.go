@@ -0,0 +1,562 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OutputSourcePriority is the POP / QPIRI output source priority.
+type OutputSourcePriority int
+
+const (
+	OutputUtilitySolarBattery OutputSourcePriority = 0
+	OutputSolarUtilityBattery OutputSourcePriority = 1
+	OutputSolarBatteryUtility OutputSourcePriority = 2
+)
+
+// ChargerSourcePriority is the PCP / PPCP / QPIRI charger source priority.
+type ChargerSourcePriority int
+
+const (
+	ChargerSolarFirst      ChargerSourcePriority = 1
+	ChargerSolarAndUtility ChargerSourcePriority = 2
+	ChargerOnlySolar       ChargerSourcePriority = 3
+)
+
+// GridWorkingRange is the PGR / QPIRI input voltage range.
+type GridWorkingRange int
+
+const (
+	GridRangeAppliance GridWorkingRange = 0
+	GridRangeUPS       GridWorkingRange = 1
+)
+
+// BatteryType is the PBT / QPIRI battery type (0: AGM, 1: Flooded, 2: User,
+// 3: Pylontech, 4: Shinheung, 5: Weco, 6: Soltaro, 7: BAK, 8: Lib, 9: Lic).
+type BatteryType int
+
+const (
+	BatteryAGM       BatteryType = 0
+	BatteryFlooded   BatteryType = 1
+	BatteryUser      BatteryType = 2
+	BatteryPylontech BatteryType = 3
+	BatteryShinheung BatteryType = 4
+	BatteryWeco      BatteryType = 5
+	BatterySoltaro   BatteryType = 6
+	BatteryBAK       BatteryType = 7
+	BatteryLib       BatteryType = 8
+	BatteryLic       BatteryType = 9
+)
+
+// OutputMode is the POPM / QPIRI output mode (0: single, 1: parallel, 2-4:
+// phase 1-3 of 3, 5-7: phase 1-2 of 2; 5-7 exist on LV models only).
+type OutputMode int
+
+const (
+	maxOutputModeHV = 4
+	maxOutputModeLV = 7
+)
+
+// Limits from the protocol for the setting commands below.
+const (
+	maxParallelMachine      = 9
+	maxBatteryTypeNum       = 9
+	maxChargingTimeAtCV     = 900
+	chargingTimeAtCVStep    = 5
+	minMaxDischargingAmps   = 30
+	maxMaxDischargingAmps   = 150
+	lvModelVoltageThreshold = 150.0 // QPIRI output rating below this means an LV (110-127V) model
+)
+
+var (
+	hvOutputVoltages = []int{220, 230, 240}
+	lvOutputVoltages = []int{110, 120, 127}
+)
+
+func validateParallelMachine(machine int) error {
+	return validateIntRange("parallel machine number", machine, 0, maxParallelMachine, 1)
+}
+
+// isLVModel reports whether QPIRI describes a 110-127V output model.
+func isLVModel(qpiriData *QPIRIData) bool {
+	return qpiriData.ACOutputRatingVoltage < lvModelVoltageThreshold
+}
+
+// ensureChargeCurrentOptions makes sure QMCHGCR/QMUCHGCR are cached for the device.
+func (s *InverterSetter) ensureChargeCurrentOptions() error {
+	if s.chargeCurrentOptions.Get(s.communicator.devicePath) != nil {
+		return nil
+	}
+	_, err := s.chargeCurrentOptions.Refresh(s.communicator, s.parser)
+	return err
+}
+
+// SetOutputSourcePriority sets the output source priority (POP<NN>).
+func (s *InverterSetter) SetOutputSourcePriority(priority OutputSourcePriority) error {
+	if err := validateIntRange("output source priority", int(priority), 0, 2, 1); err != nil {
+		return err
+	}
+	return s.applySetting(fmt.Sprintf("POP%02d", priority),
+		s.verifyQPIRI("output source priority", func(q *QPIRIData) float64 { return float64(q.OutputSourcePriority) }, float64(priority)))
+}
+
+// SetChargerSourcePriority sets the charger source priority (PCP<NN>).
+func (s *InverterSetter) SetChargerSourcePriority(priority ChargerSourcePriority) error {
+	if err := validateIntRange("charger source priority", int(priority), 1, 3, 1); err != nil {
+		return err
+	}
+	return s.applySetting(fmt.Sprintf("PCP%02d", priority),
+		s.verifyQPIRI("charger source priority", func(q *QPIRIData) float64 { return float64(q.ChargerSourcePriority) }, float64(priority)))
+}
+
+// SetParallelChargerSourcePriority sets the charger source priority of one
+// parallel unit (PPCP<MNN>), verified through QPGS<M>.
+func (s *InverterSetter) SetParallelChargerSourcePriority(machine int, priority ChargerSourcePriority) error {
+	if err := validateParallelMachine(machine); err != nil {
+		return err
+	}
+	if err := validateIntRange("charger source priority", int(priority), 1, 3, 1); err != nil {
+		return err
+	}
+	return s.applySetting(fmt.Sprintf("PPCP%d%02d", machine, priority),
+		s.verifyQPGS(machine, "charger source priority", func(q *QPGSData) float64 { return float64(q.ChargerSourcePriority) }, float64(priority)))
+}
+
+// SetGridWorkingRange sets the AC input range (PGR<NN>).
+func (s *InverterSetter) SetGridWorkingRange(gridRange GridWorkingRange) error {
+	if err := validateIntRange("grid working range", int(gridRange), 0, 1, 1); err != nil {
+		return err
+	}
+	return s.applySetting(fmt.Sprintf("PGR%02d", gridRange),
+		s.verifyQPIRI("input voltage range", func(q *QPIRIData) float64 { return float64(q.InputVoltageRange) }, float64(gridRange)))
+}
+
+// SetBatteryType sets the battery type (PBT<NN>).
+func (s *InverterSetter) SetBatteryType(batteryType BatteryType) error {
+	if err := validateIntRange("battery type", int(batteryType), 0, maxBatteryTypeNum, 1); err != nil {
+		return err
+	}
+	return s.applySetting(fmt.Sprintf("PBT%02d", batteryType),
+		s.verifyQPIRI("battery type", func(q *QPIRIData) float64 { return float64(q.BatteryType) }, float64(batteryType)))
+}
+
+// SetOutputMode sets the output mode (POPM<nn>). The split-phase modes 05-07
+// are only accepted on LV models.
+func (s *InverterSetter) SetOutputMode(mode OutputMode) error {
+	qpiriData, err := s.queryQPIRI()
+	if err != nil {
+		return err
+	}
+	maxMode := maxOutputModeHV
+	if isLVModel(qpiriData) {
+		maxMode = maxOutputModeLV
+	}
+	if err := validateIntRange("output mode", int(mode), 0, maxMode, 1); err != nil {
+		return err
+	}
+	return s.applySetting(fmt.Sprintf("POPM%02d", mode),
+		s.verifyQPIRI("output mode", func(q *QPIRIData) float64 { return float64(q.OutputMode) }, float64(mode)))
+}
+
+// setBatteryVoltage checks the planned change against the current QPIRI
+// voltages and battery type, then sends one of the nn.n voltage settings.
+func (s *InverterSetter) setBatteryVoltage(setting BatteryVoltageSetting, volts float64) error {
+	qpiriData, err := s.queryQPIRI()
+	if err != nil {
+		return err
+	}
+	return s.setBatteryVoltageFrom(qpiriData, setting, volts)
+}
+
+// setBatteryVoltageFrom is setBatteryVoltage checked against qpiriData, e.g.
+// the voltages as earlier steps of a snapshot apply leave them.
+func (s *InverterSetter) setBatteryVoltageFrom(qpiriData *QPIRIData, setting BatteryVoltageSetting, volts float64) error {
+	plan := NewBatteryVoltagePlan(qpiriData)
+	*plan.Field(setting) = volts
+	if err := ValidateBatteryVoltagePlan(plan, qpiriData, setting); err != nil {
+		return err
+	}
+	limits := batteryVoltageRanges[setting]
+	return s.applySetting(fmt.Sprintf("%s%04.1f", limits.command, volts),
+		s.verifyQPIRI(limits.name, func(q *QPIRIData) float64 { return *NewBatteryVoltagePlan(q).Field(setting) }, volts))
+}
+
+// SetBatteryCutoffVoltage sets the battery cut-off (under) voltage (PSDV<nn.n>).
+func (s *InverterSetter) SetBatteryCutoffVoltage(volts float64) error {
+	return s.setBatteryVoltage(CutoffVoltage, volts)
+}
+
+// SetBatteryCVVoltage sets the battery C.V. (bulk) charging voltage (PCVV<nn.n>).
+func (s *InverterSetter) SetBatteryCVVoltage(volts float64) error {
+	return s.setBatteryVoltage(CVVoltage, volts)
+}
+
+// SetBatteryFloatVoltage sets the battery float charging voltage (PBFT<nn.n>).
+func (s *InverterSetter) SetBatteryFloatVoltage(volts float64) error {
+	return s.setBatteryVoltage(FloatVoltage, volts)
+}
+
+// SetBatteryRechargeVoltage sets the battery re-charge voltage (PBCV<nn.n>).
+func (s *InverterSetter) SetBatteryRechargeVoltage(volts float64) error {
+	return s.setBatteryVoltage(RechargeVoltage, volts)
+}
+
+// SetBatteryRedischargeVoltage sets the battery re-discharge voltage
+// (PBDV<nn.n>); 0 means "battery full", i.e. wait for float charging.
+func (s *InverterSetter) SetBatteryRedischargeVoltage(volts float64) error {
+	return s.setBatteryVoltage(RedischargeVoltage, volts)
+}
+
+// SetMaxChargingCurrent sets the max charging current of a unit
+// (MNCHGC<mnnn>). amps must be one of the QMCHGCR values. Machine 0 is
+// verified through QPIRI, other units through QPGS<m>.
+func (s *InverterSetter) SetMaxChargingCurrent(machine, amps int) error {
+	if err := validateParallelMachine(machine); err != nil {
+		return err
+	}
+	if err := s.ensureChargeCurrentOptions(); err != nil {
+		return err
+	}
+	if err := s.chargeCurrentOptions.ValidateMaxChargingCurrent(s.communicator.devicePath, amps); err != nil {
+		return err
+	}
+	verify := s.verifyQPIRI("max charging current", func(q *QPIRIData) float64 { return float64(q.MaxChargingCurrent) }, float64(amps))
+	if machine > 0 {
+		verify = s.verifyQPGS(machine, "max charging current", func(q *QPGSData) float64 { return float64(q.MaxChargerCurrent) }, float64(amps))
+	}
+	return s.applySetting(fmt.Sprintf("MNCHGC%d%03d", machine, amps), verify)
+}
+
+// SetMaxUtilityChargingCurrent sets the max utility charging current of a unit
+// (MUCHGC<mnn>, or <mnnn> above 99A). amps must be one of the QMUCHGCR values.
+func (s *InverterSetter) SetMaxUtilityChargingCurrent(machine, amps int) error {
+	if err := validateParallelMachine(machine); err != nil {
+		return err
+	}
+	if err := s.ensureChargeCurrentOptions(); err != nil {
+		return err
+	}
+	if err := s.chargeCurrentOptions.ValidateMaxUtilityChargingCurrent(s.communicator.devicePath, amps); err != nil {
+		return err
+	}
+	verify := s.verifyQPIRI("max AC charging current", func(q *QPIRIData) float64 { return float64(q.MaxACChargingCurrent) }, float64(amps))
+	if machine > 0 {
+		verify = s.verifyQPGS(machine, "max AC charging current", func(q *QPGSData) float64 { return float64(q.MaxACChargerCurrent) }, float64(amps))
+	}
+	command := fmt.Sprintf("MUCHGC%d%02d", machine, amps)
+	if amps > 99 {
+		command = fmt.Sprintf("MUCHGC%d%03d", machine, amps)
+	}
+	return s.applySetting(command, verify)
+}
+
+// SetOutputFrequency sets the output rating frequency (F<nn>, 50 or 60).
+func (s *InverterSetter) SetOutputFrequency(hertz int) error {
+	if hertz != 50 && hertz != 60 {
+		return fmt.Errorf("output frequency %dHz must be 50 or 60", hertz)
+	}
+	return s.applySetting(fmt.Sprintf("F%02d", hertz),
+		s.verifyQPIRI("output rating frequency", func(q *QPIRIData) float64 { return q.ACOutputRatingFrequency }, float64(hertz)))
+}
+
+// validateOutputVoltage checks volts against the ratings of the model class.
+func (s *InverterSetter) validateOutputVoltage(volts int) error {
+	qpiriData, err := s.queryQPIRI()
+	if err != nil {
+		return err
+	}
+	allowed := hvOutputVoltages
+	if isLVModel(qpiriData) {
+		allowed = lvOutputVoltages
+	}
+	for _, value := range allowed {
+		if value == volts {
+			return nil
+		}
+	}
+	return fmt.Errorf("output voltage %dV is not supported by this model, allowed values: %v", volts, allowed)
+}
+
+// SetOutputVoltage sets the output rating voltage (V<nnn>; 220/230/240 on HV,
+// 110/120/127 on LV models).
+func (s *InverterSetter) SetOutputVoltage(volts int) error {
+	if err := s.validateOutputVoltage(volts); err != nil {
+		return err
+	}
+	return s.applySetting(fmt.Sprintf("V%03d", volts),
+		s.verifyQPIRI("output rating voltage", func(q *QPIRIData) float64 { return q.ACOutputRatingVoltage }, float64(volts)))
+}
+
+// SetOutputVoltageMKS sets the output rating voltage the MKSIV way (POPV<nnnn>).
+func (s *InverterSetter) SetOutputVoltageMKS(volts int) error {
+	if err := s.validateOutputVoltage(volts); err != nil {
+		return err
+	}
+	return s.applySetting(fmt.Sprintf("POPV%04d", volts),
+		s.verifyQPIRI("output rating voltage", func(q *QPIRIData) float64 { return q.ACOutputRatingVoltage }, float64(volts)))
+}
+
+// SetMaxChargingTimeAtCV sets the max charging time at the C.V. stage in
+// minutes (PCVT<nnn>, 0-900 in steps of 5, 0 means automatic).
+func (s *InverterSetter) SetMaxChargingTimeAtCV(minutes int) error {
+	if err := validateIntRange("max charging time at C.V.", minutes, 0, maxChargingTimeAtCV, chargingTimeAtCVStep); err != nil {
+		return err
+	}
+	return s.applySetting(fmt.Sprintf("PCVT%03d", minutes),
+		s.verifyQPIRI("max charging time at C.V.", func(q *QPIRIData) float64 { return float64(q.MaxChargingTimeAtCVStage) }, float64(minutes)))
+}
+
+// SetMaxDischargingCurrent sets the max battery discharging current
+// (PBATMAXDISC<nnn>, 0 to disable or 30-150A).
+func (s *InverterSetter) SetMaxDischargingCurrent(amps int) error {
+	if amps != 0 {
+		if err := validateIntRange("max discharging current", amps, minMaxDischargingAmps, maxMaxDischargingAmps, 1); err != nil {
+			return err
+		}
+	}
+	return s.applySetting(fmt.Sprintf("PBATMAXDISC%03d", amps),
+		s.verifyQPIRI("max discharging current", func(q *QPIRIData) float64 { return float64(q.MaxDischargingCurrent) }, float64(amps)))
+}
+
+// deviceFlagNames names the QFLAG flags that can be changed with PE<x>/PD<x>.
+// d (solar feed to grid) is reserved and m (battery connect) is MKSIV status only.
+var deviceFlagNames = map[string]string{
+	"a": "buzzer",
+	"b": "overload bypass",
+	"k": "LCD escape to default page",
+	"u": "overload restart",
+	"v": "over temperature restart",
+	"x": "backlight",
+	"y": "primary source interrupt alarm",
+	"z": "fault code record",
+}
+
+// SetDeviceFlag enables (PE<x>) or disables (PD<x>) one of the QFLAG flags.
+func (s *InverterSetter) SetDeviceFlag(flag string, enabled bool) error {
+	name, ok := deviceFlagNames[flag]
+	if !ok {
+		return fmt.Errorf("unknown device flag %q", flag)
+	}
+	command := "PD" + flag
+	if enabled {
+		command = "PE" + flag
+	}
+	return s.applySetting(command, s.verifyQFLAG(flag, name, enabled))
+}
+
+// SettingDefinition maps a setting name used on the command line to its typed setter.
+type SettingDefinition struct {
+	Name        string
+	Command     string
+	Description string
+	Parallel    bool // takes a parallel machine number
+	Apply       func(s *InverterSetter, machine int, value string) error
+}
+
+func parseIntSetting(value string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer value %q", value)
+	}
+	return n, nil
+}
+
+func parseFloatSetting(value string) (float64, error) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", value)
+	}
+	return f, nil
+}
+
+// intSetting adapts an int setter to the registry.
+func intSetting(set func(s *InverterSetter, value int) error) func(*InverterSetter, int, string) error {
+	return func(s *InverterSetter, machine int, value string) error {
+		n, err := parseIntSetting(value)
+		if err != nil {
+			return err
+		}
+		return set(s, n)
+	}
+}
+
+// floatSetting adapts a float setter to the registry.
+func floatSetting(set func(s *InverterSetter, value float64) error) func(*InverterSetter, int, string) error {
+	return func(s *InverterSetter, machine int, value string) error {
+		f, err := parseFloatSetting(value)
+		if err != nil {
+			return err
+		}
+		return set(s, f)
+	}
+}
+
+// parallelIntSetting adapts a per-machine int setter to the registry.
+func parallelIntSetting(set func(s *InverterSetter, machine, value int) error) func(*InverterSetter, int, string) error {
+	return func(s *InverterSetter, machine int, value string) error {
+		n, err := parseIntSetting(value)
+		if err != nil {
+			return err
+		}
+		return set(s, machine, n)
+	}
+}
+
+// boolSetting adapts an on/off setter to the registry.
+func boolSetting(set func(s *InverterSetter, value bool) error) func(*InverterSetter, int, string) error {
+	return func(s *InverterSetter, machine int, value string) error {
+		var enabled optionalBool
+		if err := enabled.Set(strings.TrimSpace(value)); err != nil {
+			return err
+		}
+		return set(s, enabled.value)
+	}
+}
+
+// flagSetting adapts SetDeviceFlag for one flag to the registry.
+func flagSetting(flag string) func(*InverterSetter, int, string) error {
+	return boolSetting(func(s *InverterSetter, enabled bool) error { return s.SetDeviceFlag(flag, enabled) })
+}
+
+// settingDefinitions lists every typed setting reachable by name.
+var settingDefinitions = map[string]SettingDefinition{
+	"output_source_priority": {Command: "POP", Description: "0: Utility-Solar-Battery, 1: Solar-Utility-Battery, 2: Solar-Battery-Utility",
+		Apply: intSetting(func(s *InverterSetter, v int) error { return s.SetOutputSourcePriority(OutputSourcePriority(v)) })},
+	"charger_source_priority": {Command: "PCP", Description: "1: solar first, 2: solar and utility, 3: only solar",
+		Apply: intSetting(func(s *InverterSetter, v int) error { return s.SetChargerSourcePriority(ChargerSourcePriority(v)) })},
+	"parallel_charger_source_priority": {Command: "PPCP", Description: "charger source priority of parallel unit -machine (1-3)", Parallel: true,
+		Apply: parallelIntSetting(func(s *InverterSetter, m, v int) error {
+			return s.SetParallelChargerSourcePriority(m, ChargerSourcePriority(v))
+		})},
+	"grid_working_range": {Command: "PGR", Description: "0: appliance, 1: UPS",
+		Apply: intSetting(func(s *InverterSetter, v int) error { return s.SetGridWorkingRange(GridWorkingRange(v)) })},
+	"battery_type": {Command: "PBT", Description: "0: AGM, 1: Flooded, 2: User, 3: Pylontech, 4: Shinheung, 5: Weco, 6: Soltaro, 7: BAK, 8: Lib, 9: Lic",
+		Apply: intSetting(func(s *InverterSetter, v int) error { return s.SetBatteryType(BatteryType(v)) })},
+	"output_mode": {Command: "POPM", Description: "0: single, 1: parallel, 2-4: phase 1-3 of 3, 5-7: phase 1-2 of 2 (LV only)",
+		Apply: intSetting(func(s *InverterSetter, v int) error { return s.SetOutputMode(OutputMode(v)) })},
+	"battery_cutoff_voltage": {Command: "PSDV", Description: "battery cut-off (under) voltage, V",
+		Apply: floatSetting((*InverterSetter).SetBatteryCutoffVoltage)},
+	"battery_cv_voltage": {Command: "PCVV", Description: "battery C.V. (bulk) charging voltage, V",
+		Apply: floatSetting((*InverterSetter).SetBatteryCVVoltage)},
+	"battery_float_voltage": {Command: "PBFT", Description: "battery float charging voltage, V",
+		Apply: floatSetting((*InverterSetter).SetBatteryFloatVoltage)},
+	"battery_recharge_voltage": {Command: "PBCV", Description: "battery re-charge voltage, V",
+		Apply: floatSetting((*InverterSetter).SetBatteryRechargeVoltage)},
+	"battery_redischarge_voltage": {Command: "PBDV", Description: "battery re-discharge voltage, V (0: battery full)",
+		Apply: floatSetting((*InverterSetter).SetBatteryRedischargeVoltage)},
+	"max_charging_current": {Command: "MNCHGC", Description: "max charging current of unit -machine, A (one of QMCHGCR)", Parallel: true,
+		Apply: parallelIntSetting((*InverterSetter).SetMaxChargingCurrent)},
+	"max_utility_charging_current": {Command: "MUCHGC", Description: "max utility charging current of unit -machine, A (one of QMUCHGCR)", Parallel: true,
+		Apply: parallelIntSetting((*InverterSetter).SetMaxUtilityChargingCurrent)},
+	"output_frequency": {Command: "F", Description: "output rating frequency, 50 or 60 Hz",
+		Apply: intSetting((*InverterSetter).SetOutputFrequency)},
+	"output_voltage": {Command: "V", Description: "output rating voltage, 220/230/240 (HV) or 110/120/127 (LV)",
+		Apply: intSetting((*InverterSetter).SetOutputVoltage)},
+	"output_voltage_mks": {Command: "POPV", Description: "output rating voltage for MKS models, V",
+		Apply: intSetting((*InverterSetter).SetOutputVoltageMKS)},
+	"max_charging_time_at_cv": {Command: "PCVT", Description: "max charging time at C.V. stage, 0-900 min in steps of 5 (0: automatic)",
+		Apply: intSetting((*InverterSetter).SetMaxChargingTimeAtCV)},
+	"max_discharging_current": {Command: "PBATMAXDISC", Description: "max discharging current, 0 (disabled) or 30-150 A",
+		Apply: intSetting((*InverterSetter).SetMaxDischargingCurrent)},
+	"equalization_enabled": {Command: "PBEQE", Description: "on/off",
+		Apply: boolSetting((*InverterSetter).SetEqualizationEnabled)},
+	"equalization_time": {Command: "PBEQT", Description: "equalization time, 5-900 min in steps of 5",
+		Apply: intSetting((*InverterSetter).SetEqualizationTime)},
+	"equalization_period": {Command: "PBEQP", Description: "equalization period, 0-90 days",
+		Apply: intSetting((*InverterSetter).SetEqualizationPeriod)},
+	"equalization_voltage": {Command: "PBEQV", Description: "equalization voltage, V (battery rating to 4/3 of it)",
+		Apply: floatSetting((*InverterSetter).SetEqualizationVoltage)},
+	"equalization_over_time": {Command: "PBEQOT", Description: "equalization over time, 5-900 min in steps of 5",
+		Apply: intSetting((*InverterSetter).SetEqualizationOverTime)},
+	"equalization_active": {Command: "PBEQA", Description: "on/off, start or stop an equalization cycle now",
+		Apply: boolSetting((*InverterSetter).SetEqualizationActive)},
+	"led_enabled": {Command: "PLEDE", Description: "on/off, LED ring",
+		Apply: boolSetting((*InverterSetter).SetLEDEnabled)},
+	"led_speed": {Command: "PLEDS", Description: "LED speed, 0: low, 1: medium, 2: fast",
+		Apply: intSetting((*InverterSetter).SetLEDSpeed)},
+	"led_effect": {Command: "PLEDM", Description: "LED effect, 0: power cycling, 1: power wheel, 2: power chasing, 3: solid on",
+		Apply: intSetting((*InverterSetter).SetLEDEffect)},
+	"led_brightness": {Command: "PLEDB", Description: "LED brightness, 0: low, 1: medium, 2: high",
+		Apply: intSetting((*InverterSetter).SetLEDBrightness)},
+	"led_presentation": {Command: "PLEDD", Description: "LED presentation, 0: PV power, 1: battery capacity, 2: load, 3: energy source, 4: battery charge/discharge",
+		Apply: intSetting((*InverterSetter).SetLEDPresentation)},
+	"led_line_color":                 {Command: "PLEDC1", Description: "line mode LED colour as r,g,b", Apply: ledColorSetting(LEDColorLineMode)},
+	"led_avr_color":                  {Command: "PLEDC2", Description: "AVR mode LED colour as r,g,b", Apply: ledColorSetting(LEDColorAVRMode)},
+	"led_battery_color":              {Command: "PLEDC3", Description: "battery mode LED colour as r,g,b", Apply: ledColorSetting(LEDColorBatteryMode)},
+	"buzzer":                         {Command: "PEa/PDa", Description: "on/off", Apply: flagSetting("a")},
+	"overload_bypass":                {Command: "PEb/PDb", Description: "on/off", Apply: flagSetting("b")},
+	"lcd_escape_to_default_page":     {Command: "PEk/PDk", Description: "on/off, return to the default LCD page after 1 min", Apply: flagSetting("k")},
+	"overload_restart":               {Command: "PEu/PDu", Description: "on/off", Apply: flagSetting("u")},
+	"over_temperature_restart":       {Command: "PEv/PDv", Description: "on/off", Apply: flagSetting("v")},
+	"backlight":                      {Command: "PEx/PDx", Description: "on/off", Apply: flagSetting("x")},
+	"primary_source_interrupt_alarm": {Command: "PEy/PDy", Description: "on/off", Apply: flagSetting("y")},
+	"fault_code_record":              {Command: "PEz/PDz", Description: "on/off", Apply: flagSetting("z")},
+}
+
+// LookupSetting returns the definition registered under name.
+func LookupSetting(name string) (SettingDefinition, bool) {
+	definition, ok := settingDefinitions[name]
+	definition.Name = name
+	return definition, ok
+}
+
+// SettingNames returns the registered setting names in alphabetical order.
+func SettingNames() []string {
+	names := make([]string, 0, len(settingDefinitions))
+	for name := range settingDefinitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runSetCommand implements the `set` subcommand.
+func runSetCommand(args []string) error {
+	fs, devicePtr := newSubcommandFlagSet("set")
+	machinePtr := fs.Int("machine", 0, "Parallel machine number for per-unit settings")
+	listPtr := fs.Bool("list", false, "List the available settings")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: go_inverter_cli set [flags] <setting> <value>\n\nFlags:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nRun with -list to see the settings.\n")
+	}
+	fs.Parse(args)
+
+	if *listPtr {
+		for _, name := range SettingNames() {
+			definition, _ := LookupSetting(name)
+			fmt.Printf("  %-34s %-12s %s\n", name, definition.Command, definition.Description)
+		}
+		return nil
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("expected a setting name and a value")
+	}
+	definition, ok := LookupSetting(fs.Arg(0))
+	if !ok {
+		return fmt.Errorf("unknown setting %q, run set -list to see the settings", fs.Arg(0))
+	}
+	if *machinePtr != 0 && !definition.Parallel {
+		return fmt.Errorf("setting %s does not take a parallel machine number", definition.Name)
+	}
+
+	communicator, err := openSubcommandDevice(*devicePtr)
+	if err != nil {
+		return err
+	}
+	defer communicator.CloseDevice()
+
+	setter := NewInverterSetter(communicator, NewInverterParser())
+	if err := definition.Apply(setter, *machinePtr, fs.Arg(1)); err != nil {
+		return err
+	}
+	if setter.dryRun {
+		fmt.Println("\nDry run: nothing was written to the inverter.")
+		return nil
+	}
+	fmt.Printf("\n%s set to %s and verified.\n", definition.Name, fs.Arg(1))
+	return nil
+}
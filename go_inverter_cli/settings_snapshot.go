@@ -0,0 +1,666 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// settingsSnapshotVersion is written into every snapshot; files with another
+// version are rejected rather than guessed at.
+const settingsSnapshotVersion = 1
+
+// SettingsSnapshot is the file written by `settings export`. Settings holds
+// registry values keyed by setting name, so `settings apply` can feed them to
+// the typed setters. The hourly priority tables are kept for reference only:
+// the protocol has no command that writes QOPPT/QCHPT.
+type SettingsSnapshot struct {
+	Version                 int               `json:"version"`
+	Created                 time.Time         `json:"created"`
+	Settings                map[string]string `json:"settings"`
+	OutputPrioritySchedule  []int             `json:"output_priority_schedule,omitempty"`
+	ChargerPrioritySchedule []int             `json:"charger_priority_schedule,omitempty"`
+}
+
+// liveSettings holds the query results a snapshot is taken from.
+type liveSettings struct {
+	qpiri           *QPIRIData
+	qflag           *QFLAGData
+	qbeqi           *QBEQIData
+	outputSchedule  *PriorityScheduleData
+	chargerSchedule *PriorityScheduleData
+}
+
+// snapshotSetting reads one registry setting from the live query results.
+// ok is false when the inverter does not report the value.
+type snapshotSetting struct {
+	name string
+	read func(live *liveSettings) (value string, ok bool)
+}
+
+func qpiriInt(field func(q *QPIRIData) int) func(*liveSettings) (string, bool) {
+	return func(live *liveSettings) (string, bool) { return strconv.Itoa(field(live.qpiri)), true }
+}
+
+func qpiriVolts(field func(q *QPIRIData) float64) func(*liveSettings) (string, bool) {
+	return func(live *liveSettings) (string, bool) { return fmt.Sprintf("%.1f", field(live.qpiri)), true }
+}
+
+func qbeqiInt(field func(q *QBEQIData) int) func(*liveSettings) (string, bool) {
+	return func(live *liveSettings) (string, bool) { return strconv.Itoa(field(live.qbeqi)), true }
+}
+
+func qflagValue(flag string) func(*liveSettings) (string, bool) {
+	return func(live *liveSettings) (string, bool) {
+		enabled, ok := live.qflag.Flags[flag]
+		return onOff(enabled), ok
+	}
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
+
+// snapshotSettings lists the captured settings in the order apply sends them.
+// The battery type goes first because it decides whether C.V. and float may be
+// changed; equalization parameters precede enabling it, as in the
+// equalization subcommand.
+var snapshotSettings = []snapshotSetting{
+	{"battery_type", qpiriInt(func(q *QPIRIData) int { return q.BatteryType })},
+	{"battery_cutoff_voltage", qpiriVolts(func(q *QPIRIData) float64 { return q.BatteryUnderVoltage })},
+	{"battery_recharge_voltage", qpiriVolts(func(q *QPIRIData) float64 { return q.BatteryRechargeVoltage })},
+	{"battery_redischarge_voltage", qpiriVolts(func(q *QPIRIData) float64 { return q.BatteryRedischargeVoltage })},
+	{"battery_cv_voltage", qpiriVolts(func(q *QPIRIData) float64 { return q.BatteryBulkVoltage })},
+	{"battery_float_voltage", qpiriVolts(func(q *QPIRIData) float64 { return q.BatteryFloatVoltage })},
+	{"output_source_priority", qpiriInt(func(q *QPIRIData) int { return q.OutputSourcePriority })},
+	{"charger_source_priority", qpiriInt(func(q *QPIRIData) int { return q.ChargerSourcePriority })},
+	{"grid_working_range", qpiriInt(func(q *QPIRIData) int { return q.InputVoltageRange })},
+	{"output_mode", qpiriInt(func(q *QPIRIData) int { return q.OutputMode })},
+	{"output_voltage", qpiriInt(func(q *QPIRIData) int { return int(math.Round(q.ACOutputRatingVoltage)) })},
+	{"output_frequency", qpiriInt(func(q *QPIRIData) int { return int(math.Round(q.ACOutputRatingFrequency)) })},
+	{"max_charging_current", qpiriInt(func(q *QPIRIData) int { return q.MaxChargingCurrent })},
+	{"max_utility_charging_current", qpiriInt(func(q *QPIRIData) int { return q.MaxACChargingCurrent })},
+	{"max_charging_time_at_cv", qpiriInt(func(q *QPIRIData) int { return q.MaxChargingTimeAtCVStage })},
+	{"max_discharging_current", qpiriInt(func(q *QPIRIData) int { return q.MaxDischargingCurrent })},
+	{"equalization_time", qbeqiInt(func(q *QBEQIData) int { return q.TimeMinutes })},
+	{"equalization_period", qbeqiInt(func(q *QBEQIData) int { return q.PeriodDays })},
+	{"equalization_voltage", func(live *liveSettings) (string, bool) { return fmt.Sprintf("%.2f", live.qbeqi.Voltage), true }},
+	{"equalization_over_time", qbeqiInt(func(q *QBEQIData) int { return q.OverTimeMinutes })},
+	{"equalization_enabled", func(live *liveSettings) (string, bool) { return onOff(live.qbeqi.Enabled), true }},
+	{"buzzer", qflagValue("a")},
+	{"overload_bypass", qflagValue("b")},
+	{"lcd_escape_to_default_page", qflagValue("k")},
+	{"overload_restart", qflagValue("u")},
+	{"over_temperature_restart", qflagValue("v")},
+	{"backlight", qflagValue("x")},
+	{"primary_source_interrupt_alarm", qflagValue("y")},
+	{"fault_code_record", qflagValue("z")},
+}
+
+// batteryVoltageSettingNames maps the registry names of the nn.n voltage settings.
+var batteryVoltageSettingNames = map[string]BatteryVoltageSetting{
+	"battery_cutoff_voltage":      CutoffVoltage,
+	"battery_recharge_voltage":    RechargeVoltage,
+	"battery_redischarge_voltage": RedischargeVoltage,
+	"battery_cv_voltage":          CVVoltage,
+	"battery_float_voltage":       FloatVoltage,
+}
+
+func isSnapshotSetting(name string) bool {
+	for _, setting := range snapshotSettings {
+		if setting.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readLiveSettings queries everything a snapshot covers.
+func (s *InverterSetter) readLiveSettings() (*liveSettings, error) {
+	live := &liveSettings{}
+	var err error
+	if live.qpiri, err = s.queryQPIRI(); err != nil {
+		return nil, err
+	}
+	time.Sleep(300 * time.Millisecond)
+	if live.qflag, err = s.queryQFLAG(); err != nil {
+		return nil, err
+	}
+	time.Sleep(300 * time.Millisecond)
+	if live.qbeqi, err = queryEqualizationStatus(s.communicator, s.parser); err != nil {
+		return nil, err
+	}
+	time.Sleep(300 * time.Millisecond)
+	if live.outputSchedule, live.chargerSchedule, err = queryPrioritySchedules(s.communicator, s.parser); err != nil {
+		return nil, err
+	}
+	return live, nil
+}
+
+// NewSettingsSnapshot captures the live settings.
+func NewSettingsSnapshot(live *liveSettings, created time.Time) *SettingsSnapshot {
+	snapshot := &SettingsSnapshot{
+		Version:                 settingsSnapshotVersion,
+		Created:                 created,
+		Settings:                make(map[string]string),
+		OutputPrioritySchedule:  append([]int(nil), live.outputSchedule.Hourly[:]...),
+		ChargerPrioritySchedule: append([]int(nil), live.chargerSchedule.Hourly[:]...),
+	}
+	for _, setting := range snapshotSettings {
+		if value, ok := setting.read(live); ok {
+			snapshot.Settings[setting.name] = value
+		}
+	}
+	return snapshot
+}
+
+// settingValuesEqual compares two registry values numerically or as on/off,
+// so a hand-edited "54" matches an exported "54.0".
+func settingValuesEqual(a, b string) bool {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	fa, errA := strconv.ParseFloat(a, 64)
+	fb, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		return math.Abs(fa-fb) <= readBackTolerance
+	}
+	var ba, bb optionalBool
+	if ba.Set(a) == nil && bb.Set(b) == nil {
+		return ba.value == bb.value
+	}
+	return strings.EqualFold(a, b)
+}
+
+// SettingChange is one setting that differs between a snapshot and the inverter.
+type SettingChange struct {
+	Name     string
+	Want     string
+	Current  string // empty when the inverter does not report it
+	Writable bool   // false for the QOPPT/QCHPT hourly tables
+}
+
+// DiffSettingsSnapshot compares the snapshot with the live settings, in apply
+// order. Settings missing from the snapshot are left alone.
+func DiffSettingsSnapshot(snapshot *SettingsSnapshot, live *liveSettings) []SettingChange {
+	var changes []SettingChange
+	for _, setting := range snapshotSettings {
+		want, ok := snapshot.Settings[setting.name]
+		if !ok {
+			continue
+		}
+		current, reported := setting.read(live)
+		if reported && settingValuesEqual(want, current) {
+			continue
+		}
+		changes = append(changes, SettingChange{Name: setting.name, Want: want, Current: current, Writable: true})
+	}
+	changes = append(changes, diffPrioritySchedule("output_priority_schedule", snapshot.OutputPrioritySchedule, live.outputSchedule)...)
+	changes = append(changes, diffPrioritySchedule("charger_priority_schedule", snapshot.ChargerPrioritySchedule, live.chargerSchedule)...)
+	return changes
+}
+
+func diffPrioritySchedule(name string, want []int, live *PriorityScheduleData) []SettingChange {
+	var changes []SettingChange
+	for hour := 0; hour < len(want) && hour < 24; hour++ {
+		if want[hour] != live.Hourly[hour] {
+			changes = append(changes, SettingChange{
+				Name:    fmt.Sprintf("%s[%02d]", name, hour),
+				Want:    strconv.Itoa(want[hour]),
+				Current: strconv.Itoa(live.Hourly[hour]),
+			})
+		}
+	}
+	return changes
+}
+
+// RenderSettingChanges writes the changes as a table.
+func RenderSettingChanges(w io.Writer, changes []SettingChange) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tFILE\tINVERTER\t")
+	for _, change := range changes {
+		current := change.Current
+		if current == "" {
+			current = "-"
+		}
+		note := ""
+		if !change.Writable {
+			note = "read-only"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", change.Name, change.Want, current, note)
+	}
+	return tw.Flush()
+}
+
+// orderBatteryVoltageChanges validates the final voltages as a whole and
+// returns an order in which they can be sent one at a time without an
+// intermediate state breaking the rules each setter enforces, e.g. raising
+// C.V. before float when both go up.
+func orderBatteryVoltageChanges(qpiriData *QPIRIData, targets map[BatteryVoltageSetting]float64) ([]BatteryVoltageSetting, error) {
+	final := NewBatteryVoltagePlan(qpiriData)
+	var pending []BatteryVoltageSetting
+	for _, setting := range []BatteryVoltageSetting{CutoffVoltage, RechargeVoltage, RedischargeVoltage, CVVoltage, FloatVoltage} {
+		if target, ok := targets[setting]; ok {
+			*final.Field(setting) = target
+			pending = append(pending, setting)
+		}
+	}
+	if err := ValidateBatteryVoltagePlan(final, qpiriData, pending...); err != nil {
+		return nil, err
+	}
+
+	current := NewBatteryVoltagePlan(qpiriData)
+	var order []BatteryVoltageSetting
+	for len(pending) > 0 {
+		progress := false
+		for i, setting := range pending {
+			step := *current
+			*step.Field(setting) = targets[setting]
+			if ValidateBatteryVoltagePlan(&step, qpiriData, setting) == nil {
+				*current = step
+				order = append(order, setting)
+				pending = append(pending[:i], pending[i+1:]...)
+				progress = true
+				break
+			}
+		}
+		if !progress {
+			return nil, fmt.Errorf("the battery voltages cannot be changed one at a time without breaking their ordering; apply them in smaller steps")
+		}
+	}
+	return order, nil
+}
+
+// ApplySettingsSnapshot sends the setter commands needed to bring the inverter
+// to the snapshot. Changes are sent in snapshotSettings order; the battery
+// voltages are ordered by orderBatteryVoltageChanges against QPIRI as read
+// after a battery type change, since the firmware may reset them. A dry run
+// reports every step; a real run stops at the first failure. It returns the
+// number of commands sent.
+func (s *InverterSetter) ApplySettingsSnapshot(snapshot *SettingsSnapshot) (int, error) {
+	for name := range snapshot.Settings {
+		if !isSnapshotSetting(name) {
+			return 0, fmt.Errorf("setting %q cannot be restored from a snapshot", name)
+		}
+	}
+
+	live, err := s.readLiveSettings()
+	if err != nil {
+		return 0, err
+	}
+	changes := DiffSettingsSnapshot(snapshot, live)
+	readOnly := 0
+	for _, change := range changes {
+		if !change.Writable {
+			readOnly++
+		}
+	}
+	if readOnly > 0 {
+		fmt.Printf("Note: %d hourly priority entries differ, but no command writes QOPPT/QCHPT\n", readOnly)
+	}
+
+	applied := 0
+	var failures []string
+	send := func(name, value string, write func() error) error {
+		time.Sleep(300 * time.Millisecond)
+		if err := write(); err != nil {
+			err = fmt.Errorf("%s %s: %w", name, value, err)
+			if !s.dryRun {
+				return err
+			}
+			fmt.Printf("Dry run: %v\n", err)
+			failures = append(failures, name)
+			return nil
+		}
+		applied++
+		return nil
+	}
+	apply := func(name, value string) error {
+		definition, _ := LookupSetting(name)
+		return send(name, value, func() error { return definition.Apply(s, 0, value) })
+	}
+
+	for _, change := range changes {
+		if change.Name == "battery_type" {
+			if err := apply(change.Name, change.Want); err != nil {
+				return applied, err
+			}
+		}
+	}
+
+	// Compare the voltages with QPIRI as it is now, after any battery type change
+	qpiriData := live.qpiri
+	if !s.dryRun {
+		time.Sleep(300 * time.Millisecond)
+		if qpiriData, err = s.queryQPIRI(); err != nil {
+			return applied, err
+		}
+	} else if want, ok := snapshot.Settings["battery_type"]; ok {
+		simulated := *qpiriData
+		if simulated.BatteryType, err = parseIntSetting(want); err != nil {
+			return applied, fmt.Errorf("battery_type: %w", err)
+		}
+		qpiriData = &simulated
+	}
+	targets := make(map[BatteryVoltageSetting]float64)
+	for name, setting := range batteryVoltageSettingNames {
+		want, ok := snapshot.Settings[name]
+		if !ok {
+			continue
+		}
+		volts, err := parseFloatSetting(want)
+		if err != nil {
+			return applied, fmt.Errorf("%s: %w", name, err)
+		}
+		if math.Abs(*NewBatteryVoltagePlan(qpiriData).Field(setting)-volts) > readBackTolerance {
+			targets[setting] = volts
+		}
+	}
+	order, err := orderBatteryVoltageChanges(qpiriData, targets)
+	if err != nil {
+		return applied, err
+	}
+	// Each step is checked against the voltages the earlier steps leave, not
+	// against QPIRI, which a dry run never changes
+	planned := *qpiriData
+	for _, setting := range order {
+		base := planned
+		volts := math.Round(targets[setting]*10) / 10
+		if err := send(settingNameOfBatteryVoltage(setting), fmt.Sprintf("%.1f", volts), func() error {
+			return s.setBatteryVoltageFrom(&base, setting, volts)
+		}); err != nil {
+			return applied, err
+		}
+		plan := NewBatteryVoltagePlan(&planned)
+		*plan.Field(setting) = volts
+		plan.ApplyTo(&planned)
+	}
+
+	for _, change := range changes {
+		if !change.Writable || change.Name == "battery_type" {
+			continue
+		}
+		if _, isVoltage := batteryVoltageSettingNames[change.Name]; isVoltage {
+			continue
+		}
+		if err := apply(change.Name, change.Want); err != nil {
+			return applied, err
+		}
+	}
+
+	if len(failures) > 0 {
+		return applied, fmt.Errorf("%d setting(s) would be rejected: %s", len(failures), strings.Join(failures, ", "))
+	}
+	return applied, nil
+}
+
+func settingNameOfBatteryVoltage(setting BatteryVoltageSetting) string {
+	for name, candidate := range batteryVoltageSettingNames {
+		if candidate == setting {
+			return name
+		}
+	}
+	return ""
+}
+
+// MarshalSettingsSnapshot encodes the snapshot as "json" or "yaml".
+func MarshalSettingsSnapshot(snapshot *SettingsSnapshot, format string) ([]byte, error) {
+	switch format {
+	case "json":
+		content, err := json.MarshalIndent(snapshot, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal settings snapshot: %w", err)
+		}
+		return append(content, '\n'), nil
+	case "yaml":
+		return marshalSnapshotYAML(snapshot), nil
+	default:
+		return nil, fmt.Errorf("unknown snapshot format %q, expected json or yaml", format)
+	}
+}
+
+// marshalSnapshotYAML writes the snapshot as YAML with the settings in apply
+// order and every value quoted, which is all parseSnapshotYAML has to read.
+func marshalSnapshotYAML(snapshot *SettingsSnapshot) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "version: %d\n", snapshot.Version)
+	fmt.Fprintf(&buf, "created: %q\n", snapshot.Created.Format(time.RFC3339))
+	fmt.Fprintln(&buf, "settings:")
+	for _, setting := range snapshotSettings {
+		if value, ok := snapshot.Settings[setting.name]; ok {
+			fmt.Fprintf(&buf, "  %s: %q\n", setting.name, value)
+		}
+	}
+	writeYAMLIntList(&buf, "output_priority_schedule", snapshot.OutputPrioritySchedule)
+	writeYAMLIntList(&buf, "charger_priority_schedule", snapshot.ChargerPrioritySchedule)
+	return buf.Bytes()
+}
+
+func writeYAMLIntList(w io.Writer, key string, values []int) {
+	if len(values) == 0 {
+		return
+	}
+	items := make([]string, len(values))
+	for i, value := range values {
+		items[i] = strconv.Itoa(value)
+	}
+	fmt.Fprintf(w, "%s: [%s]\n", key, strings.Join(items, ", "))
+}
+
+// parseSnapshotYAML reads the YAML subset marshalSnapshotYAML writes: top-level
+// scalars, the indented settings map and flow lists of integers.
+func parseSnapshotYAML(content []byte) (*SettingsSnapshot, error) {
+	snapshot := &SettingsSnapshot{Settings: make(map[string]string)}
+	inSettings := false
+	for i, line := range strings.Split(string(content), "\n") {
+		if comment := strings.Index(line, "#"); comment == 0 || (comment > 0 && line[comment-1] == ' ') {
+			line = line[:comment]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", i+1)
+		}
+		key, value := strings.TrimSpace(parts[0]), unquoteYAML(strings.TrimSpace(parts[1]))
+
+		if line[0] == ' ' || line[0] == '\t' {
+			if !inSettings {
+				return nil, fmt.Errorf("line %d: unexpected indentation", i+1)
+			}
+			snapshot.Settings[key] = value
+			continue
+		}
+		inSettings = false
+
+		var err error
+		switch key {
+		case "version":
+			snapshot.Version, err = strconv.Atoi(value)
+		case "created":
+			snapshot.Created, err = time.Parse(time.RFC3339, value)
+		case "settings":
+			inSettings = true
+		case "output_priority_schedule":
+			snapshot.OutputPrioritySchedule, err = parseYAMLIntList(value)
+		case "charger_priority_schedule":
+			snapshot.ChargerPrioritySchedule, err = parseYAMLIntList(value)
+		default:
+			err = fmt.Errorf("unknown key %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+	}
+	return snapshot, nil
+}
+
+func unquoteYAML(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			return unquoted
+		}
+	}
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+func parseYAMLIntList(value string) ([]int, error) {
+	if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+		return nil, fmt.Errorf("expected a [a, b, ...] list, got %q", value)
+	}
+	var values []int
+	for _, item := range strings.Split(strings.Trim(value, "[]"), ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil {
+			return nil, fmt.Errorf("invalid list item %q", item)
+		}
+		values = append(values, n)
+	}
+	return values, nil
+}
+
+// LoadSettingsSnapshot reads a JSON or YAML snapshot file and checks its version.
+func LoadSettingsSnapshot(path string) (*SettingsSnapshot, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settings snapshot %s: %w", path, err)
+	}
+	var snapshot *SettingsSnapshot
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("{")) {
+		snapshot = &SettingsSnapshot{}
+		err = json.Unmarshal(content, snapshot)
+	} else {
+		snapshot, err = parseSnapshotYAML(content)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse settings snapshot %s: %w", path, err)
+	}
+	if snapshot.Version != settingsSnapshotVersion {
+		return nil, fmt.Errorf("settings snapshot %s has version %d, this build reads version %d", path, snapshot.Version, settingsSnapshotVersion)
+	}
+	return snapshot, nil
+}
+
+// snapshotFormatFor picks the format from -format or the file extension.
+func snapshotFormatFor(format, path string) string {
+	if format != "" {
+		return format
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	default:
+		return "json"
+	}
+}
+
+// runSettingsCommand implements the `settings export|diff|apply` subcommand.
+func runSettingsCommand(args []string) error {
+	usage := "usage: settings export [-format json|yaml] [-o file|-] | settings diff <file> | settings apply [-dry-run] <file>"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	fs, devicePtr := newSubcommandFlagSet("settings " + args[0])
+	var formatPtr, outputPtr *string
+	switch args[0] {
+	case "export":
+		formatPtr = fs.String("format", "", "Snapshot format, json or yaml (default: from the -o extension, else json)")
+		outputPtr = fs.String("o", "", "Snapshot file (default settings_snapshot.<format>), - for stdout")
+		fs.Parse(args[1:])
+	case "diff", "apply":
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			return errors.New(usage)
+		}
+	default:
+		return fmt.Errorf("unknown settings action %q, %s", args[0], usage)
+	}
+
+	var snapshot *SettingsSnapshot
+	if args[0] != "export" {
+		var err error
+		if snapshot, err = LoadSettingsSnapshot(fs.Arg(0)); err != nil {
+			return err
+		}
+	}
+
+	communicator, err := openSubcommandDevice(*devicePtr)
+	if err != nil {
+		return err
+	}
+	defer communicator.CloseDevice()
+	setter := NewInverterSetter(communicator, NewInverterParser())
+
+	switch args[0] {
+	case "export":
+		live, err := setter.readLiveSettings()
+		if err != nil {
+			return err
+		}
+		format := snapshotFormatFor(*formatPtr, *outputPtr)
+		content, err := MarshalSettingsSnapshot(NewSettingsSnapshot(live, time.Now()), format)
+		if err != nil {
+			return err
+		}
+		// Device logs go to stdout as well, so write to a file unless - is asked for explicitly
+		outputPath := *outputPtr
+		if outputPath == "" {
+			outputPath = "settings_snapshot." + format
+		}
+		if outputPath == "-" {
+			_, err = os.Stdout.Write(content)
+			return err
+		}
+		if err := os.WriteFile(outputPath, content, 0644); err != nil {
+			return fmt.Errorf("failed to write settings snapshot %s: %w", outputPath, err)
+		}
+		fmt.Printf("Settings snapshot written to %s\n", outputPath)
+		return nil
+
+	case "diff":
+		live, err := setter.readLiveSettings()
+		if err != nil {
+			return err
+		}
+		changes := DiffSettingsSnapshot(snapshot, live)
+		if len(changes) == 0 {
+			fmt.Println("\nThe inverter matches the snapshot.")
+			return nil
+		}
+		fmt.Println()
+		return RenderSettingChanges(os.Stdout, changes)
+
+	default:
+		applied, err := setter.ApplySettingsSnapshot(snapshot)
+		if setter.dryRun {
+			if err != nil {
+				return err
+			}
+			fmt.Printf("\nDry run: %d command(s) would be sent, nothing was written to the inverter.\n", applied)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w (%d command(s) sent before stopping)", err, applied)
+		}
+		fmt.Printf("\n%d setting(s) applied and verified.\n", applied)
+		return nil
+	}
+}
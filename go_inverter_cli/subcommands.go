@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Subcommand is a one-shot action that runs instead of the polling loop,
+// e.g. `inverter-cli tou -device /dev/hidraw4`.
+type Subcommand struct {
+	Description string
+	Run         func(args []string) error
+}
+
+// subcommands maps the first command-line argument to its handler.
+var subcommands = map[string]Subcommand{
+	"backfill":     {Description: "Export historical daily PV/load energy (QED/QLD) to CSV, JSON or InfluxDB", Run: runBackfillCommand},
+	"battery":      {Description: "Show or switch battery charging and discharging (PBATCD), tracked locally", Run: runBatteryCommand},
+	"calibrate":    {Description: "Calibrate the battery voltage reading (BTA1/BTA2) against an external meter, with BTA0 rollback", Run: runCalibrateCommand},
+	"diagnostics":  {Description: "Show the Wi-Fi module link (QWFS) and BMS communication mode (QBMS)", Run: runDiagnosticsCommand},
+	"equalization": {Description: "Show battery equalization status (QBEQI) and change it (PBEQ*)", Run: runEqualizationCommand},
+	"led":          {Description: "Show the LED ring status (QLED) and change its effect, brightness and colours (PLED*)", Run: runLEDCommand},
+	"settings":     {Description: "Export the settings to a JSON/YAML snapshot, diff a snapshot against the inverter or apply it", Run: runSettingsCommand},
+	"reset":        {Description: "Guarded factory reset (PF), energy reset (RTEY), log erase (RTDL) or calibration reset (BTA0)", Run: runResetCommand},
+	"set":          {Description: "Change a setting (POP, PCP, PBT, PCVV, MNCHGC, ...) and verify it through QPIRI", Run: runSetCommand},
+	"tou":          {Description: "Render the 24-hour output/charger source priority plan", Run: runTOUCommand},
+}
+
+// printUsage lists the polling flags followed by the available subcommands.
+func printUsage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n       %s <subcommand> [flags]\n\nFlags:\n", os.Args[0], os.Args[0])
+	flag.PrintDefaults()
+
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(flag.CommandLine.Output(), "\nSubcommands:")
+	for _, name := range names {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %-12s %s\n", name, subcommands[name].Description)
+	}
+}
+
+// newSubcommandFlagSet creates a flag set with the -device, -dry-run and -audit-log flags
+// shared by all subcommands.
+func newSubcommandFlagSet(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	devicePtr := fs.String("device", "/dev/hidraw4", "Path to the hidraw device")
+	fs.BoolVar(&dryRunMode, "dry-run", false, "Print the setting commands that would be sent instead of writing them")
+	fs.StringVar(&auditLogPath, "audit-log", auditLogPath, "Append-only JSON lines log of every setting change; settings are not sent without it")
+	return fs, devicePtr
+}
+
+// openSubcommandDevice opens the inverter for a subcommand. The caller must close it.
+func openSubcommandDevice(devicePath string) (*InverterCommunicator, error) {
+	communicator := NewInverterCommunicator(devicePath)
+	if err := communicator.OpenDevice(); err != nil {
+		return nil, err
+	}
+	return communicator, nil
+}
+
+// optionalBool is a flag.Value that records whether it was set at all.
+type optionalBool struct {
+	set   bool
+	value bool
+}
+
+func (b *optionalBool) String() string {
+	if !b.set {
+		return ""
+	}
+	return boolDigit(b.value)
+}
+
+func (b *optionalBool) Set(s string) error {
+	switch s {
+	case "1", "true", "on":
+		b.value = true
+	case "0", "false", "off":
+		b.value = false
+	default:
+		return fmt.Errorf("expected on/off, got %q", s)
+	}
+	b.set = true
+	return nil
+}
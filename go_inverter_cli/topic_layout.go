@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Topic layouts selected by "topic_layout".
+const (
+	TopicLayoutJSON = "json" // one JSON object per sub-topic (the default)
+	TopicLayoutFlat = "flat" // one topic per field, see flat_topic
+	TopicLayoutBoth = "both"
+)
+
+// defaultFlatTopic is used when "flat_topic" is empty. It keeps the fields of
+// different sub-topics apart and so differs from the old C++ inverter-cli
+// topics, which need "flat_topic": "{topic}/sensor/{device}_{field}".
+const defaultFlatTopic = "{topic}/{device}/{subtopic}/{field}"
+
+// Layout returns the configured topic layout.
+func (c MQTTConfig) Layout() (string, error) {
+	switch layout := strings.ToLower(strings.TrimSpace(c.TopicLayout)); layout {
+	case "":
+		return TopicLayoutJSON, nil
+	case TopicLayoutJSON, TopicLayoutFlat, TopicLayoutBoth:
+		return layout, nil
+	default:
+		return "", fmt.Errorf("unsupported topic_layout %q, expected json, flat or both", c.TopicLayout)
+	}
+}
+
+// FieldName applies the namingMap to a field of a sub-topic. A
+// "<subtopic>/<field>" entry, e.g. "parallel/1/GridVoltage", takes precedence
+// over a plain "<field>" entry.
+func (c MQTTConfig) FieldName(subTopic, field string) string {
+	if name, ok := c.NamingMap[subTopic+"/"+field]; ok {
+		return name
+	}
+	if name, ok := c.NamingMap[field]; ok {
+		return name
+	}
+	return field
+}
+
+// FlatTopic returns the topic of one field in the flat layout.
+func (c MQTTConfig) FlatTopic(subTopic, field string) string {
+	template := c.FlatTopicTemplate
+	if template == "" {
+		template = defaultFlatTopic
+	}
+	return strings.NewReplacer(
+		"{topic}", c.Topic,
+		"{device}", c.DeviceName,
+		"{subtopic}", subTopic,
+		"{field}", c.FieldName(subTopic, field),
+	).Replace(template)
+}
+
+// jsonField is one top-level member of a JSON object, in document order.
+type jsonField struct {
+	Key   string
+	Value json.RawMessage
+}
+
+// splitJSONObject returns the members of a JSON object in their original
+// order, which a map would lose.
+func splitJSONObject(payload []byte) ([]jsonField, error) {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, fmt.Errorf("payload is not a JSON object")
+	}
+	var fields []jsonField
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := token.(string)
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		fields = append(fields, jsonField{Key: key, Value: value})
+	}
+	return fields, nil
+}
+
+// renameJSONKeys applies the namingMap to the top-level keys of a JSON object
+// payload and keeps their order. Other payloads are returned unchanged.
+func (c MQTTConfig) renameJSONKeys(subTopic string, payload []byte) []byte {
+	if len(c.NamingMap) == 0 {
+		return payload
+	}
+	fields, err := splitJSONObject(payload)
+	if err != nil {
+		return payload
+	}
+	var renamed bytes.Buffer
+	renamed.WriteByte('{')
+	for i, field := range fields {
+		if i > 0 {
+			renamed.WriteByte(',')
+		}
+		key, _ := json.Marshal(c.FieldName(subTopic, field.Key))
+		renamed.Write(key)
+		renamed.WriteByte(':')
+		renamed.Write(field.Value)
+	}
+	renamed.WriteByte('}')
+	return renamed.Bytes()
+}
+
+// flatValue is the payload of a field topic: strings without their quotes,
+// everything else (numbers, true/false, nested objects) as JSON.
+func flatValue(value json.RawMessage) []byte {
+	var text string
+	if json.Unmarshal(value, &text) == nil {
+		return []byte(text)
+	}
+	return value
+}
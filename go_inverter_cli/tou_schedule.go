@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// Output source priority values used by QOPPT.
+var outputPriorityNames = map[int]string{
+	0: "Utility first",
+	1: "Solar first",
+	2: "SBU",
+}
+
+// Charger source priority values used by QCHPT.
+var chargerPriorityNames = map[int]string{
+	1: "Solar first",
+	2: "Solar + Utility",
+	3: "Only solar",
+}
+
+func priorityName(names map[int]string, value int) string {
+	if name, ok := names[value]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown (%d)", value)
+}
+
+// queryPrioritySchedules reads the output (QOPPT) and charger (QCHPT) priority tables.
+func queryPrioritySchedules(communicator *InverterCommunicator, parser *InverterParser) (*PriorityScheduleData, *PriorityScheduleData, error) {
+	rawResponse, err := sendCommandWithTimeout(communicator, "QOPPT", 2*time.Second)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error sending QOPPT command: %w", err)
+	}
+	outputSchedule, err := parser.ParseQOPPTResponse(rawResponse)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing QOPPT response: %w", err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	rawResponse, err = sendCommandWithTimeout(communicator, "QCHPT", 2*time.Second)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error sending QCHPT command: %w", err)
+	}
+	chargerSchedule, err := parser.ParseQCHPTResponse(rawResponse)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing QCHPT response: %w", err)
+	}
+
+	return outputSchedule, chargerSchedule, nil
+}
+
+// pollPrioritySchedules queries both priority tables and publishes them under schedule/.
+func pollPrioritySchedules(communicator *InverterCommunicator, parser *InverterParser, publisher *MQTTPublisher) {
+	fmt.Println("\nSending QOPPT/QCHPT commands...")
+	outputSchedule, chargerSchedule, err := queryPrioritySchedules(communicator, parser)
+	if err != nil {
+		fmt.Printf("Error querying priority schedules: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Parsed QOPPT Data: %+v\n", outputSchedule)
+	err = publisher.PublishData(outputSchedule, "schedule/output_priority")
+	if err != nil {
+		fmt.Printf("Error publishing QOPPT data to MQTT: %v\n", err)
+	}
+
+	fmt.Printf("Parsed QCHPT Data: %+v\n", chargerSchedule)
+	err = publisher.PublishData(chargerSchedule, "schedule/charger_priority")
+	if err != nil {
+		fmt.Printf("Error publishing QCHPT data to MQTT: %v\n", err)
+	}
+}
+
+// RenderPrioritySchedules writes the 24-hour plan as a table, marking the hour
+// of now, which should be the inverter time the plan is switched by.
+func RenderPrioritySchedules(w io.Writer, outputSchedule, chargerSchedule *PriorityScheduleData, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOUR\tOUTPUT PRIORITY\tCHARGER PRIORITY\t")
+	for hour := 0; hour < 24; hour++ {
+		marker := ""
+		if hour == now.Hour() {
+			marker = "<- now"
+		}
+		fmt.Fprintf(tw, "%02d:00-%02d:59\t%s\t%s\t%s\n", hour, hour,
+			priorityName(outputPriorityNames, outputSchedule.Hourly[hour]),
+			priorityName(chargerPriorityNames, chargerSchedule.Hourly[hour]),
+			marker)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\nDevice output source priority:  %s (order %v)\n",
+		priorityName(outputPriorityNames, outputSchedule.DevicePriority), outputSchedule.PriorityOrder)
+	fmt.Fprintf(w, "Device charger source priority: %s (order %v)\n",
+		priorityName(chargerPriorityNames, chargerSchedule.DevicePriority), chargerSchedule.PriorityOrder)
+	return nil
+}
+
+// runTOUCommand implements the `tou` subcommand.
+func runTOUCommand(args []string) error {
+	fs, devicePtr := newSubcommandFlagSet("tou")
+	timezonePtr := fs.String("timezone", "Local", "IANA timezone of the inverter clock")
+	fs.Parse(args)
+
+	location, err := time.LoadLocation(*timezonePtr)
+	if err != nil {
+		return fmt.Errorf("invalid timezone %q: %w", *timezonePtr, err)
+	}
+
+	communicator, err := openSubcommandDevice(*devicePtr)
+	if err != nil {
+		return err
+	}
+	defer communicator.CloseDevice()
+	parser := NewInverterParser()
+
+	outputSchedule, chargerSchedule, err := queryPrioritySchedules(communicator, parser)
+	if err != nil {
+		return err
+	}
+
+	// The plan follows the inverter clock, which may have drifted from the host
+	time.Sleep(300 * time.Millisecond)
+	now := time.Now().In(location)
+	qtData, err := queryInverterTime(communicator, parser, location)
+	if err != nil {
+		fmt.Printf("Error reading the inverter clock, marking the host hour instead: %v\n", err)
+	} else {
+		now = qtData.InverterTime
+	}
+
+	fmt.Println()
+	return RenderPrioritySchedules(os.Stdout, outputSchedule, chargerSchedule, now)
+}